@@ -0,0 +1,61 @@
+package mealplan
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/pikans/mealplan/moira"
+)
+
+// The audit log records changes admins make (and other things worth being able to look back on),
+// one JSON object per line, in Config.AuditLogFile.
+type AuditEntry struct {
+	Time    time.Time
+	User    moira.Username // who did it
+	Action  string         // e.g. "rename-duty"
+	Details string         // e.g. `"Cleaner 1" -> "Dishes"`
+}
+
+// Add an entry to the end of the audit log.
+func AppendAudit(auditFile string, entry AuditEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(auditFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	// One write of a whole line, so entries from different programs never get mixed up
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Read the whole audit log, oldest first.
+func ReadAudit(auditFile string) ([]AuditEntry, error) {
+	file, err := os.Open(auditFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	entries := []AuditEntry{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
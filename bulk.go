@@ -0,0 +1,179 @@
+package mealplan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/pikans/mealplan/moira"
+)
+
+// Changes to many cells of the schedule at once, for the admin interface.
+
+// The kinds of bulk operation.
+const (
+	BulkClear    = "clear"     // unassign everything in the range
+	BulkClose    = "close"     // close everything in the range
+	BulkCopy     = "copy"      // copy the week containing From to the week containing Target
+	BulkEveryNth = "every-nth" // assign User on every Nth Weekday in the range
+	BulkReplace  = "replace"   // replace User with Replacement in the range
+)
+
+type BulkOp struct {
+	Kind        string
+	From, To    string   // the range of days (inclusive)
+	Duties      []string // which duties it applies to; all of them if empty
+	Target      string   // (copy) a day in the week to copy to
+	User        moira.Username
+	Replacement moira.Username // (replace)
+	Weekday     time.Weekday   // (every-nth)
+	N           int            // (every-nth) 1 for every one, 2 for every other...
+}
+
+// One cell a bulk operation changes.
+type BulkChange struct {
+	Day, Duty string
+	Old, New  moira.Username
+}
+
+// The longest range a bulk operation can cover, to catch typos in the year.
+const maxBulkDays = 400
+
+func (op BulkOp) days() ([]string, error) {
+	from, err := time.Parse(DateFormat, op.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start date %q", op.From)
+	}
+	to := from
+	if op.Kind != BulkCopy {
+		if to, err = time.Parse(DateFormat, op.To); err != nil {
+			return nil, fmt.Errorf("invalid end date %q", op.To)
+		}
+	} else {
+		// The whole week, Monday to Sunday
+		from = MondayOf(from)
+		to = from.AddDate(0, 0, 6)
+	}
+	return dayRange(from, to, maxBulkDays)
+}
+
+// Every day from one to another (inclusive), as long as that's no more than max days.
+func dayRange(from, to time.Time, max int) ([]string, error) {
+	if to.Before(from) {
+		return nil, fmt.Errorf("the end date is before the start date")
+	}
+	days := []string{}
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		days = append(days, day.Format(DateFormat))
+		if len(days) > max {
+			return nil, fmt.Errorf("that's more than %d days", max)
+		}
+	}
+	return days, nil
+}
+
+// Work out what the operation would change, without changing anything.
+func (op BulkOp) Changes(data *Data) ([]BulkChange, error) {
+	duties := op.Duties
+	if len(duties) == 0 {
+		duties = data.Duties
+	}
+	for _, duty := range duties {
+		if !data.IsActiveDuty(duty) {
+			return nil, fmt.Errorf("no duty called %q on the signup sheet", duty)
+		}
+	}
+	days, err := op.days()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []BulkChange{}
+	set := func(day, duty string, assignee moira.Username) {
+		if old := data.Assignments[day][duty]; old != assignee {
+			changes = append(changes, BulkChange{Day: day, Duty: duty, Old: old, New: assignee})
+		}
+	}
+	switch op.Kind {
+	case BulkClear, BulkClose:
+		value := moira.Username("")
+		if op.Kind == BulkClose {
+			value = "_"
+		}
+		for _, day := range days {
+			for _, duty := range duties {
+				set(day, duty, value)
+			}
+		}
+	case BulkCopy:
+		target, err := time.Parse(DateFormat, op.Target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date to copy to %q", op.Target)
+		}
+		target = MondayOf(target)
+		for i, day := range days {
+			targetDay := target.AddDate(0, 0, i).Format(DateFormat)
+			if targetDay == day {
+				return nil, fmt.Errorf("that's copying a week to itself")
+			}
+			for _, duty := range duties {
+				set(targetDay, duty, data.Assignments[day][duty])
+			}
+		}
+	case BulkEveryNth:
+		if op.User == "" {
+			return nil, fmt.Errorf("who should be assigned?")
+		}
+		if op.N < 1 {
+			return nil, fmt.Errorf("every Nth needs N to be at least 1")
+		}
+		seen := 0
+		for _, day := range days {
+			date, _ := time.Parse(DateFormat, day)
+			if date.Weekday() != op.Weekday {
+				continue
+			}
+			if seen%op.N == 0 {
+				for _, duty := range duties {
+					set(day, duty, op.User)
+				}
+			}
+			seen++
+		}
+	case BulkReplace:
+		if op.User == "" || op.Replacement == "" {
+			return nil, fmt.Errorf("replacing needs both who to replace and who with")
+		}
+		for _, day := range days {
+			for _, duty := range duties {
+				if data.Assignments[day][duty] == op.User {
+					set(day, duty, op.Replacement)
+				}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown bulk operation %q", op.Kind)
+	}
+	return changes, nil
+}
+
+// Make the changes. (For use inside Transact.)
+func ApplyChanges(data *Data, changes []BulkChange) {
+	for _, change := range changes {
+		if data.Assignments[change.Day] == nil {
+			data.Assignments[change.Day] = map[string]moira.Username{}
+		}
+		data.Assignments[change.Day][change.Duty] = change.New
+	}
+}
+
+// A short string identifying a list of changes, so a preview can be checked against what would
+// actually happen when it's applied.
+func ChangesFingerprint(changes []BulkChange) string {
+	hash := sha256.New()
+	for _, change := range changes {
+		fmt.Fprintf(hash, "%s\x00%s\x00%s\x00%s\n", change.Day, change.Duty, change.Old, change.New)
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
@@ -0,0 +1,137 @@
+package mealplan
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pikans/mealplan/moira"
+)
+
+// Two weeks of cooks, starting Monday, October 12.
+func bulkData() *Data {
+	return &Data{
+		Assignments: map[string]map[string]moira.Username{
+			"2026-10-12": {"Big Cook": "alice", "Little Cook": "bob"},
+			"2026-10-14": {"Big Cook": "carol"},
+			"2026-10-18": {"Little Cook": "_"},
+			"2026-10-19": {"Big Cook": "bob", "Little Cook": "dave"},
+			"2026-10-21": {"Big Cook": "alice", "Old Duty": "alice"},
+		},
+		Duties:        []string{"Big Cook", "Little Cook"},
+		RetiredDuties: []string{"Old Duty"},
+	}
+}
+
+func TestBulkChanges(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		op   BulkOp
+		want []BulkChange
+	}{
+		{
+			"clear one duty",
+			BulkOp{Kind: BulkClear, From: "2026-10-13", To: "2026-10-19", Duties: []string{"Big Cook"}},
+			[]BulkChange{
+				{Day: "2026-10-14", Duty: "Big Cook", Old: "carol", New: ""},
+				{Day: "2026-10-19", Duty: "Big Cook", Old: "bob", New: ""},
+			},
+		},
+		{
+			"close a weekend",
+			BulkOp{Kind: BulkClose, From: "2026-10-17", To: "2026-10-18"},
+			[]BulkChange{
+				{Day: "2026-10-17", Duty: "Big Cook", Old: "", New: "_"},
+				{Day: "2026-10-17", Duty: "Little Cook", Old: "", New: "_"},
+				{Day: "2026-10-18", Duty: "Big Cook", Old: "", New: "_"},
+			},
+		},
+		{
+			"copy a week (going by the week the days are in)",
+			BulkOp{Kind: BulkCopy, From: "2026-10-15", Target: "2026-10-25"},
+			[]BulkChange{
+				{Day: "2026-10-19", Duty: "Big Cook", Old: "bob", New: "alice"},
+				{Day: "2026-10-19", Duty: "Little Cook", Old: "dave", New: "bob"},
+				{Day: "2026-10-21", Duty: "Big Cook", Old: "alice", New: "carol"},
+				{Day: "2026-10-25", Duty: "Little Cook", Old: "", New: "_"},
+			},
+		},
+		{
+			"every other Wednesday",
+			BulkOp{Kind: BulkEveryNth, From: "2026-10-19", To: "2026-11-15", Duties: []string{"Little Cook"}, User: "erin", Weekday: time.Wednesday, N: 2},
+			[]BulkChange{
+				{Day: "2026-10-21", Duty: "Little Cook", Old: "", New: "erin"},
+				{Day: "2026-11-04", Duty: "Little Cook", Old: "", New: "erin"},
+			},
+		},
+		{
+			"replace somebody who left (but not on retired duties)",
+			BulkOp{Kind: BulkReplace, From: "2026-10-13", To: "2026-10-31", User: "alice", Replacement: "frank"},
+			[]BulkChange{
+				{Day: "2026-10-21", Duty: "Big Cook", Old: "alice", New: "frank"},
+			},
+		},
+	} {
+		data := bulkData()
+		changes, err := test.op.Changes(data)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(changes, test.want) {
+			t.Errorf("%s: changes are %v, want %v", test.name, changes, test.want)
+		}
+		if !reflect.DeepEqual(data, bulkData()) {
+			t.Errorf("%s: working out the changes changed the data", test.name)
+		}
+
+		ApplyChanges(data, changes)
+		for _, change := range changes {
+			if got := data.Assignments[change.Day][change.Duty]; got != change.New {
+				t.Errorf("%s: after applying, %s on %s is %q, want %q", test.name, change.Duty, change.Day, got, change.New)
+			}
+		}
+		if again, err := test.op.Changes(data); err != nil || len(again) != 0 {
+			t.Errorf("%s: doing it again would change %v (%v), want nothing", test.name, again, err)
+		}
+	}
+}
+
+func TestBulkChangesBadInput(t *testing.T) {
+	for _, test := range []struct {
+		op      BulkOp
+		wantErr string
+	}{
+		{BulkOp{Kind: BulkClear, From: "2026-10-12", To: "2026-10-18", Duties: []string{"Head Chef"}}, `no duty called "Head Chef"`},
+		{BulkOp{Kind: BulkClear, From: "2026-10-12", To: "2026-10-18", Duties: []string{"Old Duty"}}, `no duty called "Old Duty"`},
+		{BulkOp{Kind: BulkClear, From: "10/12/2026", To: "2026-10-18"}, "invalid start date"},
+		{BulkOp{Kind: BulkClear, From: "2026-10-12", To: ""}, "invalid end date"},
+		{BulkOp{Kind: BulkClear, From: "2026-10-18", To: "2026-10-12"}, "before the start date"},
+		{BulkOp{Kind: BulkClose, From: "2026-10-12", To: "2062-10-12"}, "more than 400 days"},
+		{BulkOp{Kind: BulkCopy, From: "2026-10-12", Target: "someday"}, "invalid date to copy to"},
+		{BulkOp{Kind: BulkCopy, From: "2026-10-12", Target: "2026-10-18"}, "copying a week to itself"},
+		{BulkOp{Kind: BulkEveryNth, From: "2026-10-12", To: "2026-10-18", Weekday: time.Monday, N: 1}, "who should be assigned"},
+		{BulkOp{Kind: BulkEveryNth, From: "2026-10-12", To: "2026-10-18", User: "erin", Weekday: time.Monday}, "N to be at least 1"},
+		{BulkOp{Kind: BulkReplace, From: "2026-10-12", To: "2026-10-18", User: "alice"}, "both who to replace and who with"},
+		{BulkOp{Kind: "shuffle", From: "2026-10-12", To: "2026-10-18"}, `unknown bulk operation "shuffle"`},
+	} {
+		_, err := test.op.Changes(bulkData())
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%+v: got error %v, want one about %q", test.op, err, test.wantErr)
+		}
+	}
+}
+
+func TestChangesFingerprint(t *testing.T) {
+	changes := []BulkChange{{Day: "2026-10-19", Duty: "Big Cook", Old: "bob", New: ""}}
+	same := []BulkChange{{Day: "2026-10-19", Duty: "Big Cook", Old: "bob", New: ""}}
+	if ChangesFingerprint(changes) != ChangesFingerprint(same) {
+		t.Error("the same changes have different fingerprints")
+	}
+	// e.g. somebody claimed it between the preview and applying it
+	different := []BulkChange{{Day: "2026-10-19", Duty: "Big Cook", Old: "carol", New: ""}}
+	if ChangesFingerprint(changes) == ChangesFingerprint(different) || ChangesFingerprint(changes) == ChangesFingerprint(nil) {
+		t.Error("different changes have the same fingerprint")
+	}
+}
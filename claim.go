@@ -0,0 +1,123 @@
+package mealplan
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/pikans/mealplan/moira"
+)
+
+// Read the data, change it with f, and write it back, unless f fails. Holds a lock (on the data file
+// plus ".lock") the whole time, so that the server and remind (handling emailed claims) never
+// overwrite each other's changes.
+func Transact(dataFile string, f func(*Data) error) error {
+	lockFile, err := os.OpenFile(dataFile+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lockFile.Close()
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+
+	currentData, err := ReadData(dataFile)
+	if err != nil {
+		return err
+	}
+	if err := f(currentData); err != nil {
+		return err
+	}
+	return WriteData(dataFile, currentData)
+}
+
+var (
+	ErrTaken    = errors.New("somebody else got this one already.")
+	ErrNotYours = errors.New("not yours, no need to abandon it.")
+	ErrPast     = errors.New("that day is over already.")
+	ErrNoDuty   = errors.New("there's no such duty on the signup sheet.")
+	ErrBadDay   = errors.New("that isn't a YYYY-MM-DD date.")
+)
+
+// Check that the duty is on the signup sheet and the day is a real day, so a made-up form can't put
+// anything in the data that CheckData would object to.
+func checkSlot(data *Data, duty, day string) error {
+	if !data.IsActiveDuty(duty) {
+		return ErrNoDuty
+	}
+	if _, err := time.Parse(DateFormat, day); err != nil {
+		return ErrBadDay
+	}
+	return nil
+}
+
+// Sign the user up for the duty on the day, if nobody has it yet. (For use inside Transact.)
+func Claim(data *Data, username moira.Username, duty, day string) error {
+	if err := checkSlot(data, duty, day); err != nil {
+		return err
+	}
+	dayAssignments, ok := data.Assignments[day]
+	if !ok {
+		dayAssignments = make(map[string]moira.Username)
+		data.Assignments[day] = dayAssignments
+	}
+	assignee, ok := dayAssignments[duty]
+	if ok && assignee != "" {
+		return ErrTaken
+	}
+	dayAssignments[duty] = username
+	return nil
+}
+
+// Take the user off the duty on the day, if they have it. (For use inside Transact.)
+func Abandon(data *Data, username moira.Username, duty, day string) error {
+	if err := checkSlot(data, duty, day); err != nil {
+		return err
+	}
+	dayAssignments, ok := data.Assignments[day]
+	if !ok {
+		return ErrNotYours
+	}
+	assignee, ok := dayAssignments[duty]
+	if !ok || assignee != username {
+		return ErrNotYours
+	}
+	dayAssignments[duty] = ""
+	return nil
+}
+
+// The email telling the kitchen manager that somebody abandoned a duty, with a copy to them if
+// they want one.
+func NewAbandonEmail(config *Config, profiles Profiles, username moira.Username, duty, day string) (*Message, error) {
+	cc := []mail.Address{}
+	if profiles.WantsEmail(username, AbandonNotification) {
+		cc = append(cc, profiles.Address(username))
+	}
+	return NewEmail("abandon",
+		mail.Address{Name: "pika kitchen website", Address: config.SMTP.From},
+		[]mail.Address{{Address: config.SMTP.From}},
+		cc,
+		AbandonEmail{
+			Name:        profiles.DisplayName(username),
+			Username:    string(username),
+			Day:         day,
+			Duty:        duty,
+			Description: config.DutyDescriptions[duty],
+			ClaimURL:    ClaimURL(config.BaseURL, "claim", duty, day),
+			SignupURL:   config.BaseURL,
+		})
+}
+
+// What to post to the webhook when somebody (name) abandons a duty.
+func AbandonWebhookEvent(config *Config, name, duty, day string) WebhookEvent {
+	return WebhookEvent{
+		Event: AbandonEvent,
+		Text:  fmt.Sprintf("%s abandoned **%s** on %s! [Claim it](%s)", name, duty, LongDayName(day), ClaimURL(config.BaseURL, "claim", duty, day)),
+		Day:   day,
+		URL:   ClaimURL(config.BaseURL, "claim", duty, day),
+	}
+}
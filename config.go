@@ -0,0 +1,508 @@
+package mealplan
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/mail"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	// So the time zone can be found even where there's no zoneinfo, e.g. in a chroot
+	_ "time/tzdata"
+
+	"github.com/pikans/mealplan/moira"
+)
+
+// default
+const ConfigFile = "mealplan-config.json"
+
+// Everything about how the server and remind are set up, read from a JSON file (see
+// mealplan-config.example.json). Anything left out of the file keeps its value from DefaultConfig.
+type Config struct {
+	DataFile     string
+	ProfilesFile string
+	OutboxDir    string // where email waits until it's been sent
+	BaseURL      string // where the signup page lives, for links in emails
+	AuditLogFile string // where changes made by admins are recorded
+	TimeZone     string // the house's time zone, e.g. "America/New_York", which decides what day it is and when reminders go out
+	TemplateDir  string // (development) directory the server re-reads its HTML templates from; empty means use the built-in ones
+
+	// Server only
+	ListenHTTP   string   // host:port to listen for HTTP on
+	ListenHTTPS  string   // host:port to listen for HTTPS on
+	Register     string   // (optional) email address for letsencrypt registration
+	Authenticate string   // path to a file containing PEM-format x509 certificates for the CAs trusted to authenticate clients
+	Authorize    string   // name of moira list whose members are authorized. The list MUST be marked as a NFS group (blanche listname -N)
+	State        string   // path at which the letsencrypt server state will be recorded
+	AdminLists   []string // moira lists whose members can use the admin interface
+	LogLevel     string   // debug, info, warn or error
+	LogFile      string   // file to append logs to; empty means stderr
+
+	SMTP      SMTPConfig
+	Directory DirectoryConfig
+
+	// Reminder groups by name, e.g. "cook" or "clean"
+	ReminderGroups map[string]ReminderGroup
+	// When "remind daemon" sends reminders, and where it records which ones it has sent
+	ReminderRules     []ReminderRule
+	SentRemindersFile string
+	// When to email the whole house about important duties nobody has claimed
+	UnfilledAlerts UnfilledAlertConfig
+	// When to send everybody the weekly digest of the coming week's schedule
+	Digest DigestConfig
+	// Where to post notifications in the house chat, and which ones
+	Webhook WebhookConfig
+	// Claiming and abandoning duties by replying to emails
+	Inbound InboundConfig
+	// What each duty involves, for emails, e.g. "Big Cook": "plan the menu and cook, 4-7pm"
+	DutyDescriptions map[string]string
+	// When each duty happens, for the printed sheet, e.g. "Big Cook": "4-7pm"
+	DutyTimes map[string]string
+
+	location *time.Location // TimeZone, loaded by Validate
+}
+
+// How to send email.
+type SMTPConfig struct {
+	Backend string // "smtp", or "maildir" to deliver everything into Maildir instead (for development)
+	Server  string // host:port
+	Maildir string // for the maildir backend
+	From    string // address emails are sent from (and BCCed to)
+}
+
+// Where to look up moira list members.
+type DirectoryConfig struct {
+	Backend    string                      // "ldap" or "static"
+	LDAPServer string                      // host:port, for the ldap backend
+	Lists      map[string][]moira.Username // list members, for the static backend (useful for testing)
+}
+
+// A set of duties which get reminded together.
+type ReminderGroup struct {
+	Duties          []string
+	ImportantDuties []string // if any of these are unfilled, dinner may be canceled
+	TodayText       string   // how to say "today" for this group, e.g. "tonight"
+}
+
+// Remind a group DaysBefore days before each meal, at a time of day (At, e.g. "14:00"). For
+// example {"cook", 1, "10:00"} reminds the cooks at 10am the day before.
+type ReminderRule struct {
+	Group      string
+	DaysBefore int
+	At         string
+}
+
+// When the reminder for a meal on the given day is due under this rule.
+func (r ReminderRule) Due(day time.Time) time.Time {
+	at, err := time.Parse("15:04", r.At)
+	if err != nil {
+		// Validate makes sure this doesn't happen
+		panic(err)
+	}
+	y, m, d := day.AddDate(0, 0, -r.DaysBefore).Date()
+	return time.Date(y, m, d, at.Hour(), at.Minute(), 0, 0, day.Location())
+}
+
+// The unfilled-shift alerts are scheduled and sent as if they were reminders for this group.
+const UnfilledAlertGroup = "unfilled"
+
+// If Enabled, email everybody (who hasn't turned these alerts off) DaysBefore days before a meal
+// whose important duties aren't all claimed, at a time of day (At), e.g. DaysBefore [3, 1, 0] nags
+// three days before, again the day before, and once more (urgently) the day of.
+type UnfilledAlertConfig struct {
+	Enabled    bool
+	DaysBefore []int
+	At         string
+}
+
+// Send the weekly digest every Weekday (e.g. "Sunday") at At (e.g. "18:00"), covering the seven days
+// starting the next day. No digest is sent if Weekday is empty.
+type DigestConfig struct {
+	Weekday string
+	At      string
+}
+
+func parseWeekday(name string) (time.Weekday, bool) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(day.String(), name) {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+// The last time the digest was due, at or before now (in now's time zone).
+func (d DigestConfig) LastDue(now time.Time) time.Time {
+	weekday, ok := parseWeekday(d.Weekday)
+	if !ok {
+		// Validate makes sure this doesn't happen
+		panic(fmt.Sprintf("bad digest weekday %q", d.Weekday))
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	day := today.AddDate(0, 0, -((int(now.Weekday()) - int(weekday) + 7) % 7))
+	due := ReminderRule{At: d.At}.Due(day)
+	if due.After(now) {
+		due = ReminderRule{At: d.At}.Due(day.AddDate(0, 0, -7))
+	}
+	return due
+}
+
+// The chat webhook (see webhook.go). Nothing is posted if URL is empty.
+type WebhookConfig struct {
+	URL            string
+	Template       string // text/template making the JSON to post from a WebhookEvent; see DefaultWebhookTemplate
+	Abandons       bool   // post when somebody abandons a duty
+	UnfilledAlerts bool   // post the UnfilledAlerts (when they're enabled)
+	CrewAt         string // time of day to post who's on duty that day, e.g. "16:00"; empty means don't
+}
+
+// Replies to reminders and unfilled-shift alerts go to Address, which should deliver them to
+// "remind inbound" (e.g. with procmail, or into a Maildir it reads). The emails carry tokens signed
+// with the key in SecretFile (made up the first time it's needed), so a reply can only act on the
+// duty the email was about, for the person it was sent to. Replying does nothing if Address is empty.
+type InboundConfig struct {
+	Address    string
+	SecretFile string
+}
+
+// Who's on duty today gets posted to the webhook (at Webhook.CrewAt) as if it were a reminder for
+// this group.
+const CrewWebhookGroup = "crew"
+
+// The configuration used when there's no config file, or for anything it leaves out.
+func DefaultConfig() *Config {
+	return &Config{
+		DataFile:     DataFile,
+		ProfilesFile: ProfilesFile,
+		OutboxDir:    "outbox",
+		BaseURL:      "https://mealplan.pikans.org/",
+		AuditLogFile: "audit.log",
+		TimeZone:     "America/New_York",
+		ListenHTTP:   ":http",
+		ListenHTTPS:  ":https",
+		AdminLists:   []string{"yfnkm", "yfncc"},
+		LogLevel:     "info",
+		SMTP: SMTPConfig{
+			Backend: "smtp",
+			Server:  "outgoing.mit.edu:smtp",
+			From:    "yfnkm@mit.edu",
+		},
+		Directory: DirectoryConfig{
+			Backend:    "ldap",
+			LDAPServer: "ldap.mit.edu:636",
+		},
+		ReminderGroups: map[string]ReminderGroup{
+			"cook":  ReminderGroup{[]string{"Big Cook", "Little Cook", "Tiny Cook"}, []string{"Big Cook", "Little Cook"}, "today"},
+			"clean": ReminderGroup{[]string{"Cleaner 1", "Cleaner 2", "Cleaner 3"}, []string{"Cleaner 1", "Cleaner 2"}, "tonight"},
+		},
+		ReminderRules: []ReminderRule{
+			{"cook", 1, "10:00"},
+			{"cook", 0, "14:00"},
+			{"clean", 0, "17:00"},
+		},
+		SentRemindersFile: "reminders-sent.json",
+		UnfilledAlerts: UnfilledAlertConfig{
+			DaysBefore: []int{3, 1, 0},
+			At:         "12:00",
+		},
+		Digest: DigestConfig{
+			At: "18:00",
+		},
+		Inbound: InboundConfig{
+			SecretFile: "reply-secret",
+		},
+	}
+}
+
+// Read the configuration from a file, and check that it makes sense
+func ReadConfig(configFile string) (*Config, error) {
+	config := DefaultConfig()
+	// JSON objects get merged into maps which are already there, so the default groups could never be
+	// taken out; use them only if the file doesn't have any
+	defaultGroups := config.ReminderGroups
+	config.ReminderGroups = nil
+	file, err := os.Open(configFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+	// Catch typos, which would otherwise silently leave the default in place
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("couldn't parse config file %s: %v", configFile, err)
+	}
+	if config.ReminderGroups == nil {
+		config.ReminderGroups = defaultGroups
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", configFile, err)
+	}
+	return config, nil
+}
+
+// Moira list names get put into LDAP queries unquoted, so they have to be this simple.
+var listNameRegexp = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// Check the configuration for mistakes. Returns an error describing all of them, if any.
+func (c *Config) Validate() error {
+	problems := []string{}
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.DataFile == "" {
+		problem("DataFile must be set")
+	}
+	if c.ProfilesFile == "" {
+		problem("ProfilesFile must be set")
+	}
+	if c.AuditLogFile == "" {
+		problem("AuditLogFile must be set")
+	}
+	if c.OutboxDir == "" {
+		problem("OutboxDir must be set")
+	}
+	if !strings.HasPrefix(c.BaseURL, "http://") && !strings.HasPrefix(c.BaseURL, "https://") {
+		problem("BaseURL %q must start with http:// or https://", c.BaseURL)
+	}
+	if location, err := time.LoadLocation(c.TimeZone); err != nil {
+		problem("TimeZone %q: %v", c.TimeZone, err)
+	} else {
+		c.location = location
+	}
+	if c.TemplateDir != "" {
+		if info, err := os.Stat(c.TemplateDir); err != nil || !info.IsDir() {
+			problem("TemplateDir %q is not a directory", c.TemplateDir)
+		}
+	}
+	for _, addr := range []string{c.ListenHTTP, c.ListenHTTPS} {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			problem("listen address %q should look like host:port: %v", addr, err)
+		}
+	}
+	if c.Authorize != "" && !listNameRegexp.MatchString(c.Authorize) {
+		problem("Authorize list %q must match %v", c.Authorize, listNameRegexp)
+	}
+	if len(c.AdminLists) == 0 {
+		problem("AdminLists must name at least one moira list")
+	}
+	for _, list := range c.AdminLists {
+		if !listNameRegexp.MatchString(list) {
+			problem("admin list %q must match %v", list, listNameRegexp)
+		}
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		problem("LogLevel %q should be debug, info, warn or error", c.LogLevel)
+	}
+
+	switch c.SMTP.Backend {
+	case "smtp":
+		if _, _, err := net.SplitHostPort(c.SMTP.Server); err != nil {
+			problem("SMTP.Server %q should look like host:port: %v", c.SMTP.Server, err)
+		}
+	case "maildir":
+		if c.SMTP.Maildir == "" {
+			problem("the maildir email backend needs SMTP.Maildir")
+		}
+	default:
+		problem("SMTP.Backend %q should be \"smtp\" or \"maildir\"", c.SMTP.Backend)
+	}
+	if !strings.Contains(c.SMTP.From, "@") {
+		problem("SMTP.From %q is not an email address", c.SMTP.From)
+	}
+
+	switch c.Directory.Backend {
+	case "ldap":
+		if _, _, err := net.SplitHostPort(c.Directory.LDAPServer); err != nil {
+			problem("Directory.LDAPServer %q should look like host:port: %v", c.Directory.LDAPServer, err)
+		}
+	case "static":
+		if len(c.Directory.Lists) == 0 {
+			problem("the static directory backend needs Directory.Lists")
+		}
+	default:
+		problem("Directory.Backend %q should be \"ldap\" or \"static\"", c.Directory.Backend)
+	}
+
+	for name, group := range c.ReminderGroups {
+		if len(group.Duties) == 0 {
+			problem("reminder group %q has no duties", name)
+		}
+		for _, important := range group.ImportantDuties {
+			found := false
+			for _, duty := range group.Duties {
+				found = found || duty == important
+			}
+			if !found {
+				problem("reminder group %q: important duty %q isn't one of its duties", name, important)
+			}
+		}
+	}
+
+	for _, rule := range c.ReminderRules {
+		if _, ok := c.ReminderGroups[rule.Group]; !ok {
+			problem("reminder rule for %q: no such reminder group", rule.Group)
+		}
+		if rule.DaysBefore < 0 {
+			problem("reminder rule for %q: DaysBefore can't be negative", rule.Group)
+		}
+		if _, err := time.Parse("15:04", rule.At); err != nil {
+			problem("reminder rule for %q: At %q should be a time like 14:00", rule.Group, rule.At)
+		}
+	}
+	for _, reserved := range []string{UnfilledAlertGroup, CrewWebhookGroup} {
+		if _, ok := c.ReminderGroups[reserved]; ok {
+			problem("ReminderGroups can't have a group called %q, which is used for UnfilledAlerts and the webhook", reserved)
+		}
+	}
+	if c.UnfilledAlerts.Enabled {
+		if _, err := time.Parse("15:04", c.UnfilledAlerts.At); err != nil {
+			problem("UnfilledAlerts.At %q should be a time like 12:00", c.UnfilledAlerts.At)
+		}
+		for _, days := range c.UnfilledAlerts.DaysBefore {
+			if days < 0 {
+				problem("UnfilledAlerts.DaysBefore can't be negative")
+			}
+		}
+	}
+	if c.Digest.Weekday != "" {
+		if _, ok := parseWeekday(c.Digest.Weekday); !ok {
+			problem("Digest.Weekday %q should be a day of the week like Sunday", c.Digest.Weekday)
+		}
+		if _, err := time.Parse("15:04", c.Digest.At); err != nil {
+			problem("Digest.At %q should be a time like 18:00", c.Digest.At)
+		}
+	}
+	if c.Webhook.URL != "" {
+		if !strings.HasPrefix(c.Webhook.URL, "http://") && !strings.HasPrefix(c.Webhook.URL, "https://") {
+			problem("Webhook.URL %q must start with http:// or https://", c.Webhook.URL)
+		}
+		if _, err := c.Webhook.Body(WebhookEvent{Event: CrewEvent, Text: "test"}); err != nil {
+			problem("Webhook.Template: %v", err)
+		}
+		if c.Webhook.CrewAt != "" {
+			if _, err := time.Parse("15:04", c.Webhook.CrewAt); err != nil {
+				problem("Webhook.CrewAt %q should be a time like 16:00", c.Webhook.CrewAt)
+			}
+		}
+	}
+	if c.Inbound.Address != "" {
+		if !strings.Contains(c.Inbound.Address, "@") {
+			problem("Inbound.Address %q is not an email address", c.Inbound.Address)
+		}
+		if c.Inbound.SecretFile == "" {
+			problem("Inbound.SecretFile must be set")
+		}
+	}
+	if (len(c.ReminderRules) != 0 || c.UnfilledAlerts.Enabled || c.Digest.Weekday != "" || c.Webhook.Wants(CrewEvent)) && c.SentRemindersFile == "" {
+		problem("SentRemindersFile must be set")
+	}
+
+	if len(problems) != 0 {
+		return fmt.Errorf("\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+// The directory the config says to look up moira lists in.
+func (d DirectoryConfig) Open() moira.Directory {
+	if d.Backend == "static" {
+		return moira.StaticDirectory(d.Lists)
+	}
+	return moira.LDAPDirectory{Server: d.LDAPServer}
+}
+
+// The house's time zone. Everything that depends on what day it is (or what time it is) should use
+// this rather than the zone the machine happens to be in, which may well be UTC.
+func (c *Config) Location() *time.Location {
+	if c.location == nil {
+		// Not validated (e.g. DefaultConfig()), so load it now
+		location, err := time.LoadLocation(c.TimeZone)
+		if err != nil {
+			return time.Local
+		}
+		c.location = location
+	}
+	return c.location
+}
+
+// Where Now gets the time from (tests pretend it's some other time).
+var timeNow = time.Now
+
+// The current time in the house's time zone.
+func (c *Config) Now() time.Time {
+	return timeNow().In(c.Location())
+}
+
+// The time at the start of the day (in DateFormat) in the house's time zone.
+func (c *Config) ParseDay(day string) (time.Time, error) {
+	return time.ParseInLocation(DateFormat, day, c.Location())
+}
+
+// The start of the Monday of the week the time is in, in its own time zone (so it should come from
+// Now or ParseDay, to be in the house's).
+func MondayOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+}
+
+// The number of days from one day to another, going by the dates, so the time of day and any DST
+// change in between (a 23 or 25 hour day) don't come into it.
+func DaysBetween(from, to time.Time) int {
+	date := func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return int(date(to).Sub(date(from)).Hours()) / 24
+}
+
+// How many weeks there are from the week one day is in through the week another is in, for showing
+// them on the signup sheet: at least 1 (even if the second day comes first), and at most max.
+func WeeksThrough(from, to time.Time, max int) int {
+	weeks := DaysBetween(MondayOf(from), MondayOf(to))/7 + 1
+	if weeks < 1 {
+		return 1
+	} else if weeks > max {
+		return max
+	}
+	return weeks
+}
+
+// Whether the day (YYYY-MM-DD) is over, in the house's time zone. Nobody can claim or abandon a duty
+// on a day that's over; only admins can change those.
+func (c *Config) IsPast(day string) bool {
+	return day < c.Now().Format(DateFormat)
+}
+
+// The duties important enough that dinner may be canceled without them (the ImportantDuties of all
+// the reminder groups).
+func (c *Config) RequiredDuties() map[string]bool {
+	required := map[string]bool{}
+	for _, group := range c.ReminderGroups {
+		for _, duty := range group.ImportantDuties {
+			required[duty] = true
+		}
+	}
+	return required
+}
+
+// The address email is sent from.
+func (s SMTPConfig) FromAddress() mail.Address {
+	return mail.Address{Name: "pika kitchen manager", Address: s.From}
+}
+
+// The mailer the config says to send email with.
+func (s SMTPConfig) Mailer() Mailer {
+	if s.Backend == "maildir" {
+		return MaildirMailer{Dir: s.Maildir}
+	}
+	return SMTPMailer{Server: s.Server}
+}
+
+// The outbox the config says to queue email in.
+func (c *Config) Outbox() (*Outbox, error) {
+	return NewOutbox(c.OutboxDir, c.SMTP.Mailer())
+}
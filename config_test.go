@@ -0,0 +1,203 @@
+package mealplan
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+	_ "time/tzdata" // (so the tests don't depend on the machine's zoneinfo)
+)
+
+// In 2026, clocks in America/New_York (the default TimeZone) spring forward at 2am on Sunday, March
+// 8, and fall back at 2am on Sunday, November 1.
+
+func houseConfig(t *testing.T) *Config {
+	config := DefaultConfig()
+	if config.Location().String() != "America/New_York" {
+		t.Fatalf("the default time zone is %v, not America/New_York", config.Location())
+	}
+	return config
+}
+
+// Pretend it's the given time (UTC) while the test runs.
+func pretendNow(t *testing.T, utc string) {
+	now, err := time.Parse(time.RFC3339, utc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeNow = func() time.Time { return now }
+	t.Cleanup(func() { timeNow = time.Now })
+}
+
+func TestNowAndIsPastAcrossDST(t *testing.T) {
+	config := houseConfig(t)
+	for _, test := range []struct {
+		utc   string
+		today string // in the house
+		hour  int
+	}{
+		{"2026-03-08T06:30:00Z", "2026-03-08", 1},  // just before springing forward
+		{"2026-03-08T07:30:00Z", "2026-03-08", 3},  // just after
+		{"2026-03-09T03:30:00Z", "2026-03-08", 23}, // a new day in UTC, but not at home
+		{"2026-03-09T04:30:00Z", "2026-03-09", 0},
+		{"2026-11-01T05:30:00Z", "2026-11-01", 1}, // the first 1:30am
+		{"2026-11-01T06:30:00Z", "2026-11-01", 1}, // the second one
+		{"2026-11-02T04:30:00Z", "2026-11-01", 23},
+		{"2026-11-02T05:30:00Z", "2026-11-02", 0},
+	} {
+		pretendNow(t, test.utc)
+		now := config.Now()
+		if got := now.Format(DateFormat); got != test.today || now.Hour() != test.hour {
+			t.Errorf("at %s, Now() = %v, want %s at %d:xx", test.utc, now, test.today, test.hour)
+		}
+		yesterday, _ := config.ParseDay(test.today)
+		yesterday = yesterday.AddDate(0, 0, -1)
+		if config.IsPast(test.today) {
+			t.Errorf("at %s, IsPast(%s) = true, want false", test.utc, test.today)
+		}
+		if !config.IsPast(yesterday.Format(DateFormat)) {
+			t.Errorf("at %s, IsPast(%s) = false, want true", test.utc, yesterday.Format(DateFormat))
+		}
+	}
+}
+
+func TestParseDayAcrossDST(t *testing.T) {
+	config := houseConfig(t)
+	for _, test := range []struct {
+		day   string
+		next  string
+		hours float64 // how long the day is
+	}{
+		{"2026-03-07", "2026-03-08", 24},
+		{"2026-03-08", "2026-03-09", 23},
+		{"2026-10-31", "2026-11-01", 24},
+		{"2026-11-01", "2026-11-02", 25},
+	} {
+		start, err := config.ParseDay(test.day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if start.Hour() != 0 || start.Minute() != 0 || start.Location() != config.Location() {
+			t.Errorf("ParseDay(%s) = %v, want midnight in the house", test.day, start)
+		}
+		next := start.AddDate(0, 0, 1)
+		if next.Format(DateFormat) != test.next || next.Hour() != 0 {
+			t.Errorf("the day after %s = %v, want midnight on %s", test.day, next, test.next)
+		}
+		if hours := next.Sub(start).Hours(); hours != test.hours {
+			t.Errorf("%s is %v hours long, want %v", test.day, hours, test.hours)
+		}
+		if days := DaysBetween(start, next); days != 1 {
+			t.Errorf("DaysBetween(%s, %s) = %d, want 1", test.day, test.next, days)
+		}
+	}
+	if _, err := config.ParseDay("2026-13-01"); err == nil {
+		t.Error("ParseDay(2026-13-01) worked")
+	}
+}
+
+func TestMondayOfAcrossDST(t *testing.T) {
+	config := houseConfig(t)
+	for _, test := range []struct {
+		utc    string
+		monday string
+	}{
+		{"2026-03-09T03:30:00Z", "2026-03-02"}, // Sunday the 8th, 11:30pm, after springing forward
+		{"2026-03-09T04:30:00Z", "2026-03-09"}, // Monday, 12:30am
+		{"2026-03-11T03:00:00Z", "2026-03-09"}, // Tuesday, 11pm
+		{"2026-11-01T06:30:00Z", "2026-10-26"}, // Sunday, the second 1:30am
+		{"2026-11-02T04:30:00Z", "2026-10-26"}, // Sunday the 1st, 11:30pm, after falling back
+		{"2026-11-02T05:30:00Z", "2026-11-02"}, // Monday, 12:30am
+		{"2026-11-04T04:00:00Z", "2026-11-02"}, // Tuesday, 11pm
+	} {
+		pretendNow(t, test.utc)
+		monday := MondayOf(config.Now())
+		if monday.Format(DateFormat) != test.monday || monday.Hour() != 0 || monday.Minute() != 0 || monday.Location() != config.Location() {
+			t.Errorf("at %s, MondayOf(Now()) = %v, want midnight on %s", test.utc, monday, test.monday)
+		}
+	}
+}
+
+// How many weeks the signup page shows from now through the end date (see windowFor in the server),
+// which used to lose the last week late in the day, or when a DST change made a week 167 or 169
+// hours long.
+func TestWeeksThroughEndDateAcrossDST(t *testing.T) {
+	config := houseConfig(t)
+	for _, test := range []struct {
+		utc     string
+		endDate string
+		weeks   int
+	}{
+		{"2026-10-21T03:00:00Z", "2026-11-04", 3}, // Tuesday, 11pm
+		{"2026-10-20T14:00:00Z", "2026-11-04", 3}, // Tuesday, 10am
+		{"2026-03-04T04:00:00Z", "2026-03-18", 3}, // Tuesday the 3rd, 11pm, springing forward in between
+		{"2026-03-09T04:30:00Z", "2026-03-09", 1}, // the end date is today
+		{"2026-10-28T03:30:00Z", "2026-11-11", 3}, // Tuesday the 27th, 11:30pm, falling back in between
+		{"2026-11-02T04:30:00Z", "2026-11-02", 2}, // Sunday the 1st, 11:30pm, ending the next day
+		{"2026-11-02T04:30:00Z", "2026-10-23", 1}, // the end date was the week before
+		{"2026-10-20T14:00:00Z", "2027-10-20", 16}, // a year away, which is too many to show
+	} {
+		pretendNow(t, test.utc)
+		end, err := config.ParseDay(test.endDate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if weeks := WeeksThrough(config.Now(), end, 16); weeks != test.weeks {
+			t.Errorf("at %s, with the end date %s, the signup page has %d weeks, want %d", test.utc, test.endDate, weeks, test.weeks)
+		}
+	}
+}
+
+func TestReminderDueAcrossDST(t *testing.T) {
+	config := houseConfig(t)
+	for _, test := range []struct {
+		day  string
+		rule ReminderRule
+		due  string // UTC
+	}{
+		{"2026-03-08", ReminderRule{DaysBefore: 1, At: "10:00"}, "2026-03-07T15:00:00Z"},
+		{"2026-03-09", ReminderRule{DaysBefore: 1, At: "10:00"}, "2026-03-08T14:00:00Z"},
+		{"2026-11-02", ReminderRule{DaysBefore: 1, At: "10:00"}, "2026-11-01T15:00:00Z"},
+		{"2026-11-01", ReminderRule{DaysBefore: 1, At: "10:00"}, "2026-10-31T14:00:00Z"},
+	} {
+		day, err := config.ParseDay(test.day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if due := test.rule.Due(day).UTC().Format(time.RFC3339); due != test.due {
+			t.Errorf("%+v for %s is due at %s, want %s", test.rule, test.day, due, test.due)
+		}
+	}
+}
+
+func writeConfig(t *testing.T, contents string) string {
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configFile, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return configFile
+}
+
+// A house with its own reminder groups shouldn't get the default ones as well.
+func TestReadConfigReplacesReminderGroups(t *testing.T) {
+	config, err := ReadConfig(writeConfig(t, `{
+		"ReminderGroups": {"dinner": {"Duties": ["Chef"], "TodayText": "tonight"}},
+		"ReminderRules": [{"Group": "dinner", "DaysBefore": 0, "At": "15:00"}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]ReminderGroup{"dinner": {Duties: []string{"Chef"}, TodayText: "tonight"}}
+	if !reflect.DeepEqual(config.ReminderGroups, want) {
+		t.Errorf("ReminderGroups = %v, want %v", config.ReminderGroups, want)
+	}
+
+	config, err = ReadConfig(writeConfig(t, `{"LogLevel": "debug"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config.ReminderGroups, DefaultConfig().ReminderGroups) {
+		t.Errorf("without any ReminderGroups, they're %v, not the defaults", config.ReminderGroups)
+	}
+}
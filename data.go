@@ -6,7 +6,9 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"time"
+
 	"github.com/pikans/mealplan/moira"
 )
 
@@ -17,11 +19,13 @@ const DateFormat = "2006-01-02"
 
 // The data that is stored on disk. A map of date to (map of duty to person), an end date, and a version ID in case of concurrent edits.
 type Data struct {
-	Assignments       map[string]map[string]moira.Username
+	Assignments map[string]map[string]moira.Username
 	//removed: PlannedAttendance map[moira.Username][]bool
-	Duties            []string
-	EndDate           string
-	VersionID         string
+	Duties        []string
+	RetiredDuties []string          // no longer on the signup sheet, but their assignments are kept
+	RetiredOn     map[string]string // the day each retired duty came off the signup sheet; the weeks before still show it
+	EndDate       string
+	VersionID     string
 }
 
 // Make the empty state: no assignments
@@ -29,6 +33,8 @@ func emptyData() *Data {
 	return &Data{
 		make(map[string]map[string]moira.Username),
 		[]string{"Big Cook", "Little Cook", "Tiny Cook", "Cleaner 1", "Cleaner 2", "Cleaner 3"},
+		nil,
+		nil,
 		time.Now().AddDate(0, 1, 0).Format(DateFormat),
 		randomVersion(),
 	}
@@ -67,8 +73,33 @@ func WriteData(dataFile string, data *Data) error {
 	if err != nil {
 		return err
 	}
-	err = ioutil.WriteFile(dataFile, jsonBytes, 0644)
-	return err
+	return WriteFileAtomically(dataFile, jsonBytes)
+}
+
+// Write a file by writing a temporary file next to it and renaming it into place, so that anybody
+// reading the file (or a crash halfway through) sees either the old contents or the new ones, never
+// a mix.
+func WriteFileAtomically(path string, contents []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once it's been renamed
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
 
 // Generate a random version string.
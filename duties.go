@@ -0,0 +1,146 @@
+package mealplan
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Changing the list of duties. Assignments are keyed by duty name, so these take care to keep them
+// in step: renaming a duty renames it in every day's assignments, and retiring one just takes it off
+// the signup sheet from then on, keeping everything anybody did under it (and showing it in the
+// weeks before, see DutiesForWeek).
+
+// Check that a (new) duty name is usable: duty names go into form field names like
+// "claim/<duty>/<day>", so they can't contain slashes.
+func ValidDutyName(name string) error {
+	switch {
+	case strings.TrimSpace(name) == "":
+		return fmt.Errorf("a duty needs a name")
+	case name != strings.TrimSpace(name):
+		return fmt.Errorf("duty %q can't start or end with spaces", name)
+	case strings.Contains(name, "/"):
+		return fmt.Errorf("duty %q can't contain slashes", name)
+	}
+	return nil
+}
+
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// Whether the duty exists, either on the signup sheet or retired.
+func (data *Data) HasDuty(name string) bool {
+	return indexOf(data.Duties, name) != -1 || indexOf(data.RetiredDuties, name) != -1
+}
+
+// Whether the duty is on the signup sheet (not retired).
+func (data *Data) IsActiveDuty(name string) bool {
+	return indexOf(data.Duties, name) != -1
+}
+
+// The duties to show for the week starting on the day (YYYY-MM-DD): the ones on the signup sheet,
+// then any retired after the week started, so the history of who did them doesn't disappear.
+func (data *Data) DutiesForWeek(firstDay string) []string {
+	duties := append([]string{}, data.Duties...)
+	for _, duty := range data.RetiredDuties {
+		if retiredOn := data.RetiredOn[duty]; retiredOn > firstDay {
+			duties = append(duties, duty)
+		}
+	}
+	return duties
+}
+
+// Add a new duty at the end of the signup sheet.
+func AddDuty(data *Data, name string) error {
+	if err := ValidDutyName(name); err != nil {
+		return err
+	}
+	if data.HasDuty(name) {
+		return fmt.Errorf("there's already a duty called %q", name)
+	}
+	data.Duties = append(data.Duties, name)
+	return nil
+}
+
+// Rename a duty (active or retired), including in all its assignments.
+func RenameDuty(data *Data, oldName, newName string) error {
+	if !data.HasDuty(oldName) {
+		return fmt.Errorf("no duty called %q", oldName)
+	}
+	if err := ValidDutyName(newName); err != nil {
+		return err
+	}
+	if oldName == newName {
+		return nil
+	}
+	if data.HasDuty(newName) {
+		return fmt.Errorf("there's already a duty called %q", newName)
+	}
+	for _, list := range [][]string{data.Duties, data.RetiredDuties} {
+		if i := indexOf(list, oldName); i != -1 {
+			list[i] = newName
+		}
+	}
+	for _, dayAssignments := range data.Assignments {
+		if assignee, ok := dayAssignments[oldName]; ok {
+			dayAssignments[newName] = assignee
+			delete(dayAssignments, oldName)
+		}
+	}
+	if retiredOn, ok := data.RetiredOn[oldName]; ok {
+		data.RetiredOn[newName] = retiredOn
+		delete(data.RetiredOn, oldName)
+	}
+	return nil
+}
+
+// Move a duty up (delta < 0) or down the signup sheet.
+func MoveDuty(data *Data, name string, delta int) error {
+	i := indexOf(data.Duties, name)
+	if i == -1 {
+		return fmt.Errorf("no duty called %q on the signup sheet", name)
+	}
+	j := i + delta
+	if j < 0 || j >= len(data.Duties) {
+		return fmt.Errorf("can't move %q any further", name)
+	}
+	duty := data.Duties[i]
+	data.Duties = append(data.Duties[:i], data.Duties[i+1:]...)
+	data.Duties = append(data.Duties[:j], append([]string{duty}, data.Duties[j:]...)...)
+	return nil
+}
+
+// Take a duty off the signup sheet from the day (YYYY-MM-DD) on, keeping its assignments.
+func RetireDuty(data *Data, name, day string) error {
+	i := indexOf(data.Duties, name)
+	if i == -1 {
+		return fmt.Errorf("no duty called %q on the signup sheet", name)
+	}
+	if len(data.Duties) == 1 {
+		return fmt.Errorf("can't retire the last duty")
+	}
+	data.Duties = append(data.Duties[:i], data.Duties[i+1:]...)
+	data.RetiredDuties = append(data.RetiredDuties, name)
+	if data.RetiredOn == nil {
+		data.RetiredOn = map[string]string{}
+	}
+	data.RetiredOn[name] = day
+	return nil
+}
+
+// Put a retired duty back at the end of the signup sheet.
+func RestoreDuty(data *Data, name string) error {
+	i := indexOf(data.RetiredDuties, name)
+	if i == -1 {
+		return fmt.Errorf("no retired duty called %q", name)
+	}
+	data.RetiredDuties = append(data.RetiredDuties[:i], data.RetiredDuties[i+1:]...)
+	delete(data.RetiredOn, name)
+	data.Duties = append(data.Duties, name)
+	return nil
+}
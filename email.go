@@ -0,0 +1,243 @@
+package mealplan
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// The email templates. Each kind of email has a .txt template (text/template), which defines
+// "subject" and "text", and a .html template (html/template), which defines "html". They're executed
+// with the same data.
+//
+//go:embed emails/*.txt emails/*.html
+var emailTemplateFiles embed.FS
+
+var emailFuncs = map[string]interface{}{
+	"dayName":      LongDayName,
+	"shortDayName": ShortDayName,
+}
+
+// How to write a day (in DateFormat) out in full, e.g. "Monday, January 2".
+func LongDayName(day string) string {
+	date, err := time.Parse(DateFormat, day)
+	if err != nil {
+		return day
+	}
+	return date.Format("Monday, January 2")
+}
+
+// How to write a day (in DateFormat) briefly, e.g. "Mon 1/2".
+func ShortDayName(day string) string {
+	date, err := time.Parse(DateFormat, day)
+	if err != nil {
+		return day
+	}
+	return date.Format("Mon 1/2")
+}
+
+// Put together an email from the templates for the given kind (e.g. "reminder" uses
+// emails/reminder.txt and emails/reminder.html), with both a plain text and an HTML version. It's
+// addressed to the To and Cc addresses; add anybody to BCC to the returned message's To.
+func NewEmail(kind string, from mail.Address, to, cc []mail.Address, data interface{}) (*Message, error) {
+	return NewReplyableEmail(kind, from, nil, to, cc, data)
+}
+
+// The same as NewEmail, but replies go to replyTo (if it isn't nil) instead of from.
+func NewReplyableEmail(kind string, from mail.Address, replyTo *mail.Address, to, cc []mail.Address, data interface{}) (*Message, error) {
+	// Every kind defines the same template names, so each gets parsed on its own
+	t, err := template.New("").Funcs(emailFuncs).ParseFS(emailTemplateFiles, "emails/"+kind+".txt")
+	if err != nil {
+		return nil, err
+	}
+	h, err := htmltemplate.New("").Funcs(emailFuncs).ParseFS(emailTemplateFiles, "emails/"+kind+".html")
+	if err != nil {
+		return nil, err
+	}
+	var subject, text, html bytes.Buffer
+	if err := t.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := t.ExecuteTemplate(&text, "text", data); err != nil {
+		return nil, err
+	}
+	if err := h.ExecuteTemplate(&html, "html", data); err != nil {
+		return nil, err
+	}
+	body, err := composeMessage(from, replyTo, to, cc, strings.TrimSpace(subject.String()), text.String(), html.String())
+	if err != nil {
+		return nil, err
+	}
+	msg := &Message{From: from.Address, Body: body}
+	for _, addr := range append(append([]mail.Address{}, to...), cc...) {
+		msg.To = append(msg.To, addr.Address)
+	}
+	return msg, nil
+}
+
+func formatAddresses(addrs []mail.Address) string {
+	formatted := []string{}
+	for _, addr := range addrs {
+		// String() takes care of quoting and encoding the name
+		formatted = append(formatted, addr.String())
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// Build a complete RFC 5322 message with a multipart/alternative body holding the text and HTML
+// versions. Names and the subject are encoded if they aren't plain ASCII.
+func ComposeMessage(from mail.Address, to, cc []mail.Address, subject, text, html string) ([]byte, error) {
+	return composeMessage(from, nil, to, cc, subject, text, html)
+}
+
+func composeMessage(from mail.Address, replyTo *mail.Address, to, cc []mail.Address, subject, text, html string) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	domain := "localhost"
+	if at := strings.LastIndex(from.Address, "@"); at != -1 {
+		domain = from.Address[at+1:]
+	}
+	replyToHeader := ""
+	if replyTo != nil {
+		replyToHeader = replyTo.String()
+	}
+	headers := []struct{ name, value string }{
+		{"From", from.String()},
+		{"To", formatAddresses(to)},
+		{"Cc", formatAddresses(cc)},
+		{"Reply-To", replyToHeader},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), randomID(), domain)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", writer.Boundary())},
+	}
+	for _, header := range headers {
+		if header.value != "" {
+			fmt.Fprintf(&buf, "%s: %s\r\n", header.name, header.value)
+		}
+	}
+	buf.WriteString("\r\n")
+
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", html},
+	} {
+		w, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		// (this also turns the line endings into CRLF, as email wants)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// A link to the page which claims or abandons (action) a duty.
+func ClaimURL(baseURL, action, duty, day string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/claim?" + url.Values{"action": {action}, "duty": {duty}, "day": {day}}.Encode()
+}
+
+// What goes into a reminder email (emails/reminder.*), which is about one person's shift.
+type ReminderEmail struct {
+	Name            string // who it's to
+	Task            string // e.g. "cook tomorrow"
+	Day             string
+	Duty            string
+	Description     string
+	Teammates       []Teammate // everybody else on the same shift
+	MightBeCanceled bool
+	OpenDuties      []OpenDuty // the important duties nobody has claimed, if MightBeCanceled
+	AbandonURL      string
+	AbandonToken    string // for abandoning by replying, if that's set up
+	SignupURL       string
+}
+
+type Teammate struct {
+	Name, Duty string
+}
+
+type OpenDuty struct {
+	Duty, ClaimURL string
+	ClaimToken     string // for claiming by replying, if that's set up
+}
+
+// What goes into the email sent when somebody abandons a duty (emails/abandon.*).
+type AbandonEmail struct {
+	Name        string
+	Username    string
+	Day         string
+	Duty        string
+	Description string
+	ClaimURL    string
+	SignupURL   string
+}
+
+// What goes into the email to the whole house about important duties nobody has claimed
+// (emails/unfilled.*).
+type UnfilledEmail struct {
+	Day        string
+	DaysLeft   int
+	OpenDuties []OpenDuty
+	SignupURL  string
+}
+
+// What goes into the weekly digest (emails/digest.*), which shows one person the coming week's
+// schedule.
+type DigestEmail struct {
+	Name           string // who it's to
+	Duties         []string
+	Days           []DigestDay
+	OpenCount      int // how many of the shifts nobody has claimed
+	MyShifts       int // how many the recipient has
+	SignupURL      string
+	PreferencesURL string
+}
+
+// One day in the digest: a shift for each of the Duties, in order.
+type DigestDay struct {
+	Day    string
+	Shifts []DigestShift
+}
+
+type DigestShift struct {
+	Duty     string
+	Name     string // who claimed it, if anybody
+	Open     bool
+	Closed   bool
+	Mine     bool
+	ClaimURL string // if Open
+}
+
+// What goes into the reply to somebody who emailed a command (emails/reply.*).
+type ReplyResultEmail struct {
+	Name      string
+	Action    string // what they asked for, if we could tell
+	Duty      string
+	Day       string
+	Problem   string // why it didn't work, if it didn't
+	SignupURL string
+}
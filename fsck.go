@@ -0,0 +1,213 @@
+package mealplan
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pikans/mealplan/moira"
+)
+
+// Checking the data for things that can't be right, which can only get there by editing the file by
+// hand (or by a bug): ReadData takes whatever JSON it finds. The server checks at startup and
+// refuses to run with serious problems; "mealplanctl fsck -fix" fixes what it can.
+
+// Something wrong with the data.
+type Problem struct {
+	Description string
+	Serious     bool // whether it breaks things, rather than just being odd (like assignments for people who have since left)
+	fix         func(*Data)
+}
+
+// Whether FixData can fix the problem.
+func (p Problem) Fixable() bool {
+	return p.fix != nil
+}
+
+func (p Problem) String() string {
+	s := p.Description
+	if !p.Serious {
+		s = "warning: " + s
+	}
+	if p.Fixable() {
+		s += " (fixable)"
+	}
+	return s
+}
+
+// Find the problems with the data. If members isn't nil, it's everybody who's allowed to sign up,
+// and anybody else who is assigned something from today (YYYY-MM-DD) on gets a warning.
+func CheckData(data *Data, members []moira.Username, today string) []Problem {
+	problems := []Problem{}
+	if data.Assignments == nil {
+		problems = append(problems, Problem{Description: "there are no assignments at all (not even an empty list)", Serious: true, fix: func(data *Data) {
+			data.Assignments = map[string]map[string]moira.Username{}
+		}})
+	}
+	if len(data.Duties) == 0 {
+		problems = append(problems, Problem{Description: "there are no duties on the signup sheet", Serious: true})
+	}
+
+	seen := map[string]bool{}
+	for _, duty := range append(append([]string{}, data.Duties...), data.RetiredDuties...) {
+		duty := duty
+		if seen[duty] {
+			problems = append(problems, Problem{Description: fmt.Sprintf("duty %q is listed more than once", duty), Serious: true, fix: func(data *Data) {
+				data.Duties = withoutDuplicates(data.Duties, map[string]bool{})
+				data.RetiredDuties = withoutDuplicates(data.RetiredDuties, listed(data.Duties))
+			}})
+			continue
+		}
+		seen[duty] = true
+		if err := ValidDutyName(duty); err != nil {
+			p := Problem{Description: err.Error(), Serious: true}
+			newName := strings.TrimSpace(strings.ReplaceAll(duty, "/", "-"))
+			if ValidDutyName(newName) == nil && !data.HasDuty(newName) {
+				p.Description += fmt.Sprintf(" (would be renamed to %q)", newName)
+				p.fix = func(data *Data) {
+					if data.HasDuty(newName) {
+						// (another fix got there first)
+						return
+					}
+					// (RenameDuty would refuse the old name)
+					for _, list := range [][]string{data.Duties, data.RetiredDuties} {
+						if i := indexOf(list, duty); i != -1 {
+							list[i] = newName
+						}
+					}
+					for _, dayAssignments := range data.Assignments {
+						if assignee, ok := dayAssignments[duty]; ok {
+							dayAssignments[newName] = assignee
+							delete(dayAssignments, duty)
+						}
+					}
+					if retiredOn, ok := data.RetiredOn[duty]; ok {
+						data.RetiredOn[newName] = retiredOn
+						delete(data.RetiredOn, duty)
+					}
+				}
+			}
+			problems = append(problems, p)
+		}
+	}
+
+	if _, err := time.Parse(DateFormat, data.EndDate); err != nil {
+		problems = append(problems, Problem{Description: fmt.Sprintf("the end date %q isn't a YYYY-MM-DD date (would be set to the last day anybody is assigned, or a month from now)", data.EndDate), Serious: true, fix: func(data *Data) {
+			data.EndDate = today
+			if t, err := time.Parse(DateFormat, today); err == nil {
+				data.EndDate = t.AddDate(0, 1, 0).Format(DateFormat)
+			}
+			for day := range data.Assignments {
+				if _, err := time.Parse(DateFormat, day); err == nil && day > data.EndDate {
+					data.EndDate = day
+				}
+			}
+		}})
+	}
+
+	isMember := map[moira.Username]bool{}
+	for _, u := range members {
+		isMember[u] = true
+	}
+	nonMembers := map[moira.Username]int{}
+	days := []string{}
+	for day := range data.Assignments {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	for _, day := range days {
+		day, dayAssignments := day, data.Assignments[day]
+		if _, err := time.Parse(DateFormat, day); err != nil {
+			problems = append(problems, Problem{Description: fmt.Sprintf("there are assignments for %q, which isn't a YYYY-MM-DD date (would be dropped: %v)", day, dayAssignments), Serious: true, fix: func(data *Data) {
+				delete(data.Assignments, day)
+			}})
+			continue
+		}
+		duties := []string{}
+		for duty := range dayAssignments {
+			duties = append(duties, duty)
+		}
+		sort.Strings(duties)
+		for _, duty := range duties {
+			duty, assignee := duty, dayAssignments[duty]
+			if !data.HasDuty(duty) && assignee != "" {
+				problems = append(problems, Problem{Description: fmt.Sprintf("%s is assigned %q on %s, which isn't a duty (would be added to the retired duties)", assignee, duty, day), Serious: true, fix: func(data *Data) {
+					if data.HasDuty(duty) {
+						return
+					}
+					// Retired the day after its last assignment, so the weeks it was done in still show it
+					last := ""
+					for day, dayAssignments := range data.Assignments {
+						if _, err := time.Parse(DateFormat, day); err == nil && dayAssignments[duty] != "" && day > last {
+							last = day
+						}
+					}
+					retiredOn := today
+					if date, err := time.Parse(DateFormat, last); err == nil {
+						retiredOn = date.AddDate(0, 0, 1).Format(DateFormat)
+					}
+					data.RetiredDuties = append(data.RetiredDuties, duty)
+					if data.RetiredOn == nil {
+						data.RetiredOn = map[string]string{}
+					}
+					data.RetiredOn[duty] = retiredOn
+				}})
+			}
+			if members != nil && assignee != "" && assignee != "_" && !isMember[assignee] && day >= today {
+				nonMembers[assignee]++
+			}
+		}
+	}
+	names := []string{}
+	for u := range nonMembers {
+		names = append(names, string(u))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		problems = append(problems, Problem{Description: fmt.Sprintf("%s is assigned %d upcoming shifts, but isn't on the list of people who can sign up", name, nonMembers[moira.Username(name)])})
+	}
+	return problems
+}
+
+func listed(list []string) map[string]bool {
+	set := map[string]bool{}
+	for _, s := range list {
+		set[s] = true
+	}
+	return set
+}
+
+// The list without any duplicates, or anything in seen.
+func withoutDuplicates(list []string, seen map[string]bool) []string {
+	result := []string{}
+	for _, s := range list {
+		if !seen[s] {
+			result = append(result, s)
+			seen[s] = true
+		}
+	}
+	return result
+}
+
+// Fix whichever of the problems (from CheckData) can be fixed. Returns the ones which were.
+func FixData(data *Data, problems []Problem) []Problem {
+	fixed := []Problem{}
+	for _, p := range problems {
+		if p.Fixable() {
+			p.fix(data)
+			fixed = append(fixed, p)
+		}
+	}
+	return fixed
+}
+
+// Whether any of the problems are serious.
+func AnySerious(problems []Problem) bool {
+	for _, p := range problems {
+		if p.Serious {
+			return true
+		}
+	}
+	return false
+}
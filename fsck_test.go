@@ -0,0 +1,100 @@
+package mealplan
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pikans/mealplan/moira"
+)
+
+func descriptions(problems []Problem) string {
+	lines := []string{}
+	for _, p := range problems {
+		lines = append(lines, p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestCheckDataFine(t *testing.T) {
+	data := sheetData()
+	data.EndDate = "2026-12-31"
+	if problems := CheckData(data, []moira.Username{"alice", "bob", "carol"}, "2026-10-19"); len(problems) != 0 {
+		t.Errorf("found problems with fine data:\n%s", descriptions(problems))
+	}
+}
+
+func TestCheckAndFixData(t *testing.T) {
+	data := &Data{
+		Assignments: map[string]map[string]moira.Username{
+			"2026-10-12": {"Big Cook": "alice", "Snack/Dessert": "bob"},
+			"2026-10-19": {"Big Cook": "dave", "Head Chef": "carol"},
+			"2026-10-20": {"Head Chef": "carol", "Big Cook": "_"},
+			"2026-10-26": {"Head Chef": ""},
+			"10/21/2026": {"Big Cook": "alice"},
+		},
+		Duties:        []string{"Big Cook", "Snack/Dessert", "Big Cook"},
+		RetiredDuties: []string{"Old Duty"},
+		RetiredOn:     map[string]string{"Old Duty": "2026-01-05"},
+		EndDate:       "the end of the year",
+	}
+	problems := CheckData(data, []moira.Username{"alice", "bob", "carol"}, "2026-10-19")
+	want := []string{
+		`duty "Snack/Dessert" can't contain slashes (would be renamed to "Snack-Dessert") (fixable)`,
+		`duty "Big Cook" is listed more than once (fixable)`,
+		`the end date "the end of the year" isn't a YYYY-MM-DD date (would be set to the last day anybody is assigned, or a month from now) (fixable)`,
+		`there are assignments for "10/21/2026", which isn't a YYYY-MM-DD date (would be dropped: map[Big Cook:alice]) (fixable)`,
+		`carol is assigned "Head Chef" on 2026-10-19, which isn't a duty (would be added to the retired duties) (fixable)`,
+		`carol is assigned "Head Chef" on 2026-10-20, which isn't a duty (would be added to the retired duties) (fixable)`,
+		`warning: dave is assigned 1 upcoming shifts, but isn't on the list of people who can sign up`,
+	}
+	if got := descriptions(problems); got != strings.Join(want, "\n") {
+		t.Errorf("found:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+	if !AnySerious(problems) {
+		t.Error("none of them are serious")
+	}
+
+	fixed := FixData(data, problems)
+	if len(fixed) != len(want)-1 {
+		t.Errorf("fixed %d problems, want all but the warning:\n%s", len(fixed), descriptions(fixed))
+	}
+	if problems := CheckData(data, nil, "2026-10-19"); len(problems) != 0 {
+		t.Errorf("after fixing, there are still problems:\n%s", descriptions(problems))
+	}
+	if want := []string{"Big Cook", "Snack-Dessert"}; !reflect.DeepEqual(data.Duties, want) {
+		t.Errorf("the duties are %q, want %q", data.Duties, want)
+	}
+	if want := []string{"Old Duty", "Head Chef"}; !reflect.DeepEqual(data.RetiredDuties, want) {
+		t.Errorf("the retired duties are %q, want %q", data.RetiredDuties, want)
+	}
+	// Retired the day after carol last did it, so it's still shown that week, but not after
+	if want := map[string]string{"Old Duty": "2026-01-05", "Head Chef": "2026-10-21"}; !reflect.DeepEqual(data.RetiredOn, want) {
+		t.Errorf("RetiredOn is %v, want %v", data.RetiredOn, want)
+	}
+	if duties := data.DutiesForWeek("2026-10-19"); !reflect.DeepEqual(duties, []string{"Big Cook", "Snack-Dessert", "Head Chef"}) {
+		t.Errorf("the week of the 19th shows %q", duties)
+	}
+	if duties := data.DutiesForWeek("2026-10-26"); !reflect.DeepEqual(duties, data.Duties) {
+		t.Errorf("the week of the 26th shows %q", duties)
+	}
+	if data.Assignments["2026-10-12"]["Snack-Dessert"] != "bob" || data.Assignments["10/21/2026"] != nil {
+		t.Errorf("the assignments are now %v", data.Assignments)
+	}
+	if data.EndDate != "2026-11-19" {
+		t.Errorf("the end date is %s, want a month from today", data.EndDate)
+	}
+}
+
+func TestFixUnknownDutyOnlyClosed(t *testing.T) {
+	// Closed counts as an assignment, since it's shown on the signup sheet
+	data := &Data{
+		Assignments: map[string]map[string]moira.Username{"2026-10-12": {"Big Cook": "alice", "Head Chef": "_"}},
+		Duties:      []string{"Big Cook"},
+		EndDate:     "2026-12-31",
+	}
+	FixData(data, CheckData(data, nil, "2026-10-19"))
+	if data.RetiredOn["Head Chef"] != "2026-10-13" {
+		t.Errorf("RetiredOn is %v, want Head Chef retired on 2026-10-13", data.RetiredOn)
+	}
+}
@@ -0,0 +1,83 @@
+package mealplan
+
+import (
+	"fmt"
+	"io"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An email ready to go: the envelope sender, everybody who should get it (including any BCCs), and
+// the whole message, headers and all.
+type Message struct {
+	From string
+	To   []string
+	Body []byte
+}
+
+// Something which sends email.
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+// Sends email through an SMTP server.
+type SMTPMailer struct {
+	Server string // host:port
+}
+
+func (m SMTPMailer) Send(msg *Message) error {
+	return smtp.SendMail(m.Server, nil, msg.From, msg.To, msg.Body)
+}
+
+// Instead of sending email, delivers it into a maildir (e.g. to read with mutt -f), for development.
+type MaildirMailer struct {
+	Dir string
+}
+
+func (m MaildirMailer) Send(msg *Message) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(m.Dir, sub), 0755); err != nil {
+			return err
+		}
+	}
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%s.%s", time.Now().UnixNano(), randomID(), hostname)
+	// Maildir delivery: write into tmp, then move into new once it's complete
+	tmpPath := filepath.Join(m.Dir, "tmp", name)
+	body := append([]byte(fmt.Sprintf("X-Envelope-To: %v\r\n", msg.To)), msg.Body...)
+	if err := os.WriteFile(tmpPath, body, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(m.Dir, "new", name))
+}
+
+// Instead of sending email, prints it out, for trying things out.
+type WriterMailer struct {
+	W io.Writer
+}
+
+func (m WriterMailer) Send(msg *Message) error {
+	_, err := fmt.Fprintf(m.W, "======== envelope from %s to %s\n%s\n", msg.From, strings.Join(msg.To, ", "), msg.Body)
+	return err
+}
+
+// Remembers email instead of sending it, for tests. If Err is set, fails to send with it instead.
+type FakeMailer struct {
+	sync.Mutex
+	Sent []Message
+	Err  error
+}
+
+func (m *FakeMailer) Send(msg *Message) error {
+	m.Lock()
+	defer m.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	m.Sent = append(m.Sent, *msg)
+	return nil
+}
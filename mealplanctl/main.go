@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/user"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	. "github.com/pikans/mealplan"
+	"github.com/pikans/mealplan/moira"
+)
+
+// mealplanctl makes the same changes to the data file as the admin pages do, from the command line,
+// so they can be scripted. Changes go through Transact, so they never clobber (or get clobbered by)
+// the server's, and get written to the audit log like the server's do.
+
+var configFile = flag.String("config", ConfigFile, "path to the JSON configuration file (see mealplan-config.example.json)")
+var ifVersion = flag.String("if-version", "", "only make the change if the data's VersionID (see show) is still this")
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: %[1]s [flags] show [-week YYYY-MM-DD]
+           print the week (Monday to Sunday) with the day in it, this week by default
+       %[1]s [flags] assign <YYYY-MM-DD> <duty> <username>
+           sign somebody up for a duty (username _ closes it), if nobody else has it
+       %[1]s [flags] unassign <YYYY-MM-DD> <duty>
+           take whoever has a duty off it (or open it, if it was closed)
+       %[1]s [flags] set-end-date <YYYY-MM-DD>
+           set the last day of the signup sheet
+       %[1]s [flags] duties add <name>
+       %[1]s [flags] duties rename <old name> <new name>
+           add a duty (at the end), or rename one (along with everything assigned to it)
+       %[1]s [flags] fsck [-fix]
+           check the data file for problems (validate does the same), and fix the ones which can
+           be fixed
+       %[1]s [flags] export [-format json|csv|xlsx] [-layout wide|long] [-from YYYY-MM-DD] [-to YYYY-MM-DD]
+           print the whole data file (to be edited and imported), or the assignments as a
+           spreadsheet
+       %[1]s [flags] import [-force] [-dry-run] <file>
+           replace the whole data file with an exported one, unless it changed since the export
+           (or -force); or change the assignments in a CSV or XLSX spreadsheet laid out like the
+           exported ones, printing the changes (or with -dry-run, only printing them)
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	flag.Usage = usage
+	flag.Parse()
+
+	config, err := ReadConfig(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	args := flag.Args()
+	switch {
+	case len(args) >= 1 && args[0] == "show":
+		show(config, args[1:])
+	case len(args) == 4 && args[0] == "assign":
+		assign(config, args[1], args[2], moira.Username(args[3]))
+	case len(args) == 3 && args[0] == "unassign":
+		unassign(config, args[1], args[2])
+	case len(args) == 2 && args[0] == "set-end-date":
+		setEndDate(config, args[1])
+	case len(args) == 3 && args[0] == "duties" && args[1] == "add":
+		change(config, "add-duty", fmt.Sprintf("%q", args[2]), func(data *Data) error {
+			return AddDuty(data, args[2])
+		})
+	case len(args) == 4 && args[0] == "duties" && args[1] == "rename":
+		change(config, "rename-duty", fmt.Sprintf("%q -> %q", args[2], args[3]), func(data *Data) error {
+			return RenameDuty(data, args[2], args[3])
+		})
+	case len(args) >= 1 && (args[0] == "fsck" || args[0] == "validate"):
+		fsck(config, args[1:])
+	case len(args) >= 1 && args[0] == "export":
+		export(config, args[1:])
+	case len(args) >= 1 && args[0] == "import":
+		importData(config, args[1:])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+var errVersionChanged = errors.New("the data has changed since that version (check with show and try again)")
+
+// Change the data with f (in a transaction, and only if it's still -if-version, if that's given),
+// and record it in the audit log.
+func change(config *Config, action, details string, f func(*Data) error) {
+	err := Transact(config.DataFile, func(data *Data) error {
+		if *ifVersion != "" && data.VersionID != *ifVersion {
+			return errVersionChanged
+		}
+		return f(data)
+	})
+	if err != nil {
+		log.Fatalf("%s: %v", action, err)
+	}
+	audit(config, action, details)
+}
+
+// Who's running this, for the audit log.
+func whoami() moira.Username {
+	if u, err := user.Current(); err == nil {
+		return moira.Username(u.Username)
+	}
+	return moira.Username(os.Getenv("USER"))
+}
+
+func audit(config *Config, action, details string) {
+	err := AppendAudit(config.AuditLogFile, AuditEntry{User: whoami(), Action: action, Details: "(mealplanctl) " + details})
+	if err != nil {
+		log.Printf("couldn't write to the audit log: %v", err)
+	}
+}
+
+func checkDay(day string) {
+	if _, err := time.Parse(DateFormat, day); err != nil {
+		log.Fatalf("invalid date %q, please provide a date in YYYY-MM-DD format", day)
+	}
+}
+
+// Implements "mealplanctl assign".
+func assign(config *Config, day, duty string, username moira.Username) {
+	checkDay(day)
+	change(config, "assign", fmt.Sprintf("%s %q: %s", day, duty, username), func(data *Data) error {
+		if !data.IsActiveDuty(duty) {
+			return fmt.Errorf("no duty %q", duty)
+		}
+		if assignee := data.Assignments[day][duty]; assignee == username {
+			return nil
+		} else if assignee != "" {
+			return fmt.Errorf("%s already has it (unassign it first)", assignee)
+		}
+		return Claim(data, username, duty, day)
+	})
+}
+
+// Implements "mealplanctl unassign".
+func unassign(config *Config, day, duty string) {
+	checkDay(day)
+	change(config, "unassign", fmt.Sprintf("%s %q", day, duty), func(data *Data) error {
+		if !data.HasDuty(duty) {
+			return fmt.Errorf("no duty %q", duty)
+		}
+		if data.Assignments[day][duty] == "" {
+			return nil
+		}
+		data.Assignments[day][duty] = ""
+		return nil
+	})
+}
+
+// Implements "mealplanctl set-end-date".
+func setEndDate(config *Config, day string) {
+	checkDay(day)
+	change(config, "set-end-date", day, func(data *Data) error {
+		data.EndDate = day
+		return nil
+	})
+}
+
+// Implements "mealplanctl show".
+func show(config *Config, args []string) {
+	flags := flag.NewFlagSet("show", flag.ExitOnError)
+	week := flags.String("week", "", "(YYYY-MM-DD) show the week with this day in it")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	day := config.Now()
+	if *week != "" {
+		var err error
+		if day, err = config.ParseDay(*week); err != nil {
+			log.Fatalf("invalid date %q, please provide a date in YYYY-MM-DD format", *week)
+		}
+	}
+	monday := MondayOf(day)
+
+	data, err := ReadData(config.DataFile)
+	if err != nil {
+		log.Fatalf("couldn't read data from '%s': %v", config.DataFile, err)
+	}
+	fmt.Printf("Version: %s\nEnd date: %s\n\n", data.VersionID, data.EndDate)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	days := []string{}
+	header := []string{""}
+	for i := 0; i < 7; i++ {
+		date := monday.AddDate(0, 0, i)
+		days = append(days, date.Format(DateFormat))
+		header = append(header, date.Format("Mon 2006-01-02"))
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, duty := range data.DutiesForWeek(days[0]) {
+		row := []string{duty}
+		for _, day := range days {
+			switch assignee := data.Assignments[day][duty]; assignee {
+			case "":
+				row = append(row, "-")
+			case "_":
+				row = append(row, "(closed)")
+			default:
+				row = append(row, string(assignee))
+			}
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+}
+
+// The people who can sign up (see CheckData), or nil if that can't be found out.
+func members(config *Config) []moira.Username {
+	if config.Authorize == "" {
+		return nil
+	}
+	members, err := config.Directory.Open().Members(config.Authorize)
+	if err != nil {
+		log.Printf("not checking who's assigned, since the %s list couldn't be looked up: %v", config.Authorize, err)
+		return nil
+	}
+	return members
+}
+
+// Implements "mealplanctl fsck" (and "validate"): prints the problems with the data file, and fixes
+// those it can with -fix. Exits with status 1 if there are serious problems left.
+func fsck(config *Config, args []string) {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	fix := flags.Bool("fix", false, "fix the problems which can be fixed")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	members := members(config)
+	today := config.Now().Format(DateFormat)
+	var problems []Problem
+	if !*fix {
+		data, err := ReadData(config.DataFile)
+		if err != nil {
+			log.Fatalf("couldn't read data from '%s': %v", config.DataFile, err)
+		}
+		problems = CheckData(data, members, today)
+	} else {
+		var fixed []Problem
+		change(config, "fsck", "fixing problems", func(data *Data) error {
+			fixed = FixData(data, CheckData(data, members, today))
+			problems = CheckData(data, members, today)
+			return nil
+		})
+		for _, p := range fixed {
+			fmt.Printf("fixed: %s\n", p.Description)
+		}
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if AnySerious(problems) {
+		os.Exit(1)
+	}
+}
+
+// Implements "mealplanctl export": the whole data file as JSON, or the assignments as a spreadsheet
+// (see AssignmentRows).
+func export(config *Config, args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "json", "json (the whole data file), csv or xlsx")
+	layout := flags.String("layout", WideLayout, "(csv and xlsx) wide (a row for each day) or long (a row for each assignment)")
+	from := flags.String("from", "", "(csv and xlsx; YYYY-MM-DD) the first day, by default the first anybody is assigned")
+	to := flags.String("to", "", "(csv and xlsx; YYYY-MM-DD) the last day, by default the end date")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	data, err := ReadData(config.DataFile)
+	if err != nil {
+		log.Fatalf("couldn't read data from '%s': %v", config.DataFile, err)
+	}
+	if *format == "json" {
+		jsonBytes, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(append(jsonBytes, '\n'))
+		return
+	}
+
+	if *from == "" {
+		*from = data.EndDate
+		for day := range data.Assignments {
+			if day < *from {
+				*from = day
+			}
+		}
+	}
+	if *to == "" {
+		*to = data.EndDate
+	}
+	rows, err := AssignmentRows(data, *from, *to, *layout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := WriteTable(os.Stdout, rows, *format); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Implements "mealplanctl import". A JSON file replaces the whole data file, but its VersionID (from
+// when it was exported) has to match the current data's, so nobody's claims since the export get
+// thrown away, unless -force is given. A spreadsheet just changes the assignments in it (see
+// ImportRows), and the changes are printed.
+func importData(config *Config, args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	force := flags.Bool("force", false, "(json) import even if the data has changed since the file was exported")
+	dryRun := flags.Bool("dry-run", false, "(csv and xlsx) just print what would change")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	contents, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !strings.HasPrefix(strings.TrimSpace(string(contents)), "{") {
+		importTable(config, flags.Arg(0), contents, *dryRun)
+		return
+	}
+
+	imported := new(Data)
+	if err := json.Unmarshal(contents, imported); err != nil {
+		log.Fatalf("couldn't read %s: %v", flags.Arg(0), err)
+	}
+	if len(imported.Duties) == 0 {
+		log.Fatalf("%s has no duties", flags.Arg(0))
+	}
+	for _, duty := range append(append([]string{}, imported.Duties...), imported.RetiredDuties...) {
+		if err := ValidDutyName(duty); err != nil {
+			log.Fatalf("%s: %v", flags.Arg(0), err)
+		}
+	}
+	if imported.Assignments == nil {
+		imported.Assignments = map[string]map[string]moira.Username{}
+	}
+	// Anything which would break the signup sheet (whoever's on it doesn't matter here)
+	if problems := CheckData(imported, nil, config.Now().Format(DateFormat)); AnySerious(problems) {
+		for _, p := range problems {
+			if p.Serious {
+				fmt.Fprintln(os.Stderr, p)
+			}
+		}
+		log.Fatalf("not importing %s, because of the problems above", flags.Arg(0))
+	}
+
+	change(config, "import", flags.Arg(0), func(data *Data) error {
+		if !*force && imported.VersionID != data.VersionID {
+			return fmt.Errorf("the data has changed since %s was exported (export it again, or use -force to overwrite the changes)", flags.Arg(0))
+		}
+		*data = *imported
+		return nil
+	})
+}
+
+func importTable(config *Config, name string, contents []byte, dryRun bool) {
+	rows, err := ReadTable(contents)
+	if err != nil {
+		log.Fatalf("couldn't read %s: %v", name, err)
+	}
+	var changes []BulkChange
+	printChanges := func() {
+		for _, c := range changes {
+			fmt.Printf("%s %s: %q -> %q\n", c.Day, c.Duty, c.Old, c.New)
+		}
+	}
+	if dryRun {
+		data, err := ReadData(config.DataFile)
+		if err != nil {
+			log.Fatalf("couldn't read data from '%s': %v", config.DataFile, err)
+		}
+		if changes, err = ImportRows(data, rows); err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+		printChanges()
+		return
+	}
+	change(config, "import-spreadsheet", name, func(data *Data) error {
+		var err error
+		if changes, err = ImportRows(data, rows); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		ApplyChanges(data, changes)
+		return nil
+	})
+	printChanges()
+	fmt.Printf("%d changes\n", len(changes))
+}
@@ -4,14 +4,35 @@ import (
 	"crypto/tls"
 	"fmt"
 	"log"
+	"net"
 	"strings"
 
 	"gopkg.in/ldap.v2"
 )
 
+// Somewhere to look up the members of moira lists.
+type Directory interface {
+	Members(nfsgroup string) ([]Username, error)
+}
+
+// Looks up lists in an LDAP server which mirrors moira, like ldap.mit.edu.
+type LDAPDirectory struct {
+	Server string // host:port
+}
+
+// A fixed set of lists, for testing without access to LDAP.
+type StaticDirectory map[string][]Username
+
+// The directory used by GetMoiraNFSGroupMembers and IsAuthorized.
+var Dir Directory = LDAPDirectory{Server: "ldap.mit.edu:636"}
+
 // nfsgroup MUST match [a-z0-9-] (no LDAP quoting is done)
-func GetMoiraNFSGroupMemberStrings(nfsgroup string) ([]string, error) {
-	l, err := ldap.DialTLS("tcp", "ldap.mit.edu:636", &tls.Config{ServerName: "ldap.mit.edu"})
+func (d LDAPDirectory) memberStrings(nfsgroup string) ([]string, error) {
+	host, _, err := net.SplitHostPort(d.Server)
+	if err != nil {
+		return nil, err
+	}
+	l, err := ldap.DialTLS("tcp", d.Server, &tls.Config{ServerName: host})
 	if err != nil {
 		log.Print(err)
 		return nil, err
@@ -45,8 +66,8 @@ func extractPart(prefix, suffix, str string) (string, bool) {
 	}
 }
 
-func GetMoiraNFSGroupMembers(nfsgroup string) ([]Username, error) {
-	members, err := GetMoiraNFSGroupMemberStrings(nfsgroup)
+func (d LDAPDirectory) Members(nfsgroup string) ([]Username, error) {
+	members, err := d.memberStrings(nfsgroup)
 	if err != nil {
 		return nil, err
 	}
@@ -64,6 +85,18 @@ func GetMoiraNFSGroupMembers(nfsgroup string) ([]Username, error) {
 	return usernames, nil
 }
 
+func (d StaticDirectory) Members(nfsgroup string) ([]Username, error) {
+	members, ok := d[nfsgroup]
+	if !ok {
+		return nil, fmt.Errorf("no list %q in the static directory", nfsgroup)
+	}
+	return members, nil
+}
+
+func GetMoiraNFSGroupMembers(nfsgroup string) ([]Username, error) {
+	return Dir.Members(nfsgroup)
+}
+
 func IsAuthorized(authorize string, user Username) error {
 	users, err := GetMoiraNFSGroupMembers(authorize)
 	if err != nil {
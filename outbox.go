@@ -0,0 +1,176 @@
+package mealplan
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Outgoing email waits in an outbox (a directory with one JSON file per message) until it's been
+// sent, so that a slow or broken SMTP server never holds up a web request, and mail that fails to
+// send gets retried later instead of being lost. Both the server and remind put mail here; whichever
+// of them flushes the outbox next sends it.
+type Outbox struct {
+	Dir    string
+	Mailer Mailer
+	wake   chan struct{}
+}
+
+// A message waiting in the outbox.
+type queuedMessage struct {
+	Message
+	Queued      time.Time
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+}
+
+// How long to wait before retrying a message which has failed to send this many times: a minute,
+// doubling every time up to six hours.
+func retryDelay(attempts int) time.Duration {
+	delay := time.Minute
+	for i := 1; i < attempts && delay < 6*time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > 6*time.Hour {
+		delay = 6 * time.Hour
+	}
+	return delay
+}
+
+// Give up on messages (moving them into failed/ for a human to look at) after this many attempts,
+// which is a bit over four days.
+const maxSendAttempts = 25
+
+func NewOutbox(dir string, mailer Mailer) (*Outbox, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "failed"), 0755); err != nil {
+		return nil, err
+	}
+	return &Outbox{Dir: dir, Mailer: mailer, wake: make(chan struct{}, 1)}, nil
+}
+
+func randomID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Put a message in the outbox to be sent as soon as possible.
+func (o *Outbox) Enqueue(msg *Message) error {
+	now := time.Now()
+	q := queuedMessage{Message: *msg, Queued: now, NextAttempt: now}
+	name := fmt.Sprintf("%d-%s.json", now.UnixNano(), randomID())
+	if err := o.write(filepath.Join(o.Dir, name), &q); err != nil {
+		return err
+	}
+	// Let Run know there's something to send (unless it already knows)
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// An outbox is also a Mailer, which "sends" email by putting it in the outbox.
+func (o *Outbox) Send(msg *Message) error {
+	return o.Enqueue(msg)
+}
+
+func (o *Outbox) write(path string, q *queuedMessage) error {
+	jsonBytes, err := json.MarshalIndent(q, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomically(path, jsonBytes)
+}
+
+// Try to send every message in the outbox which is due. Messages which fail get retried later.
+// Returns the number of messages still waiting.
+func (o *Outbox) Flush() (int, error) {
+	// Make sure the server and remind don't both send the same message at once
+	lockFile, err := os.OpenFile(filepath.Join(o.Dir, ".lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer lockFile.Close()
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		return 0, err
+	}
+	defer syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+
+	paths, err := filepath.Glob(filepath.Join(o.Dir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+	waiting := 0
+	for _, path := range paths {
+		jsonBytes, err := os.ReadFile(path)
+		if err != nil {
+			return waiting, err
+		}
+		var q queuedMessage
+		if err := json.Unmarshal(jsonBytes, &q); err != nil {
+			log.Printf("outbox: can't read %s, moving it to failed/: %v", path, err)
+			os.Rename(path, filepath.Join(o.Dir, "failed", filepath.Base(path)))
+			continue
+		}
+		if time.Now().Before(q.NextAttempt) {
+			waiting++
+			continue
+		}
+
+		err = o.Mailer.Send(&q.Message)
+		if err == nil {
+			if err := os.Remove(path); err != nil {
+				return waiting, err
+			}
+			continue
+		}
+
+		q.Attempts++
+		q.LastError = err.Error()
+		if q.Attempts >= maxSendAttempts {
+			log.Printf("outbox: giving up on mail to %s after %d attempts: %v", strings.Join(q.To, ", "), q.Attempts, err)
+			if err := o.write(filepath.Join(o.Dir, "failed", filepath.Base(path)), &q); err != nil {
+				return waiting, err
+			}
+			os.Remove(path)
+			continue
+		}
+		q.NextAttempt = time.Now().Add(retryDelay(q.Attempts))
+		log.Printf("outbox: couldn't send mail to %s (attempt %d, retrying at %s): %v", strings.Join(q.To, ", "), q.Attempts, q.NextAttempt.Format(time.Kitchen), err)
+		if err := o.write(path, &q); err != nil {
+			return waiting, err
+		}
+		waiting++
+	}
+	return waiting, nil
+}
+
+// Keep flushing the outbox, whenever something is enqueued and otherwise every interval (to retry
+// failures and pick up mail left by other programs), until the context is canceled.
+func (o *Outbox) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := o.Flush(); err != nil {
+			log.Printf("outbox: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package mealplan
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testOutbox(t *testing.T) (*Outbox, *FakeMailer) {
+	mailer := &FakeMailer{}
+	outbox, err := NewOutbox(t.TempDir(), mailer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return outbox, mailer
+}
+
+func enqueue(t *testing.T, outbox *Outbox, to string) {
+	if err := outbox.Enqueue(&Message{From: "mealplan@example.com", To: []string{to}, Body: []byte("Subject: hi\r\n\r\nhi\r\n")}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func flush(t *testing.T, outbox *Outbox, wantWaiting int) {
+	waiting, err := outbox.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if waiting != wantWaiting {
+		t.Errorf("%d messages are waiting, want %d", waiting, wantWaiting)
+	}
+}
+
+func readQueued(t *testing.T, path string) queuedMessage {
+	jsonBytes, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var q queuedMessage
+	if err := json.Unmarshal(jsonBytes, &q); err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+// The messages waiting in the outbox, by file name.
+func queued(t *testing.T, outbox *Outbox) map[string]queuedMessage {
+	paths, err := filepath.Glob(filepath.Join(outbox.Dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := map[string]queuedMessage{}
+	for _, path := range paths {
+		messages[path] = readQueued(t, path)
+	}
+	return messages
+}
+
+// The file name of the one message in the outbox.
+func onlyQueued(t *testing.T, outbox *Outbox) string {
+	messages := queued(t, outbox)
+	if len(messages) != 1 {
+		t.Fatalf("there are %d messages in the outbox, not 1", len(messages))
+	}
+	for path := range messages {
+		return path
+	}
+	return ""
+}
+
+// Change a queued message, as if time had passed.
+func requeue(t *testing.T, outbox *Outbox, path string, change func(*queuedMessage)) {
+	q := readQueued(t, path)
+	change(&q)
+	if err := outbox.write(path, &q); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOutboxFlush(t *testing.T) {
+	outbox, mailer := testOutbox(t)
+	enqueue(t, outbox, "alice@mit.edu")
+	enqueue(t, outbox, "bob@mit.edu")
+	flush(t, outbox, 0)
+	if len(mailer.Sent) != 2 || mailer.Sent[0].To[0] != "alice@mit.edu" || mailer.Sent[1].To[0] != "bob@mit.edu" {
+		t.Errorf("sent %v, want alice's and then bob's", mailer.Sent)
+	}
+	if left := queued(t, outbox); len(left) != 0 {
+		t.Errorf("left %v in the outbox", left)
+	}
+	flush(t, outbox, 0)
+	if len(mailer.Sent) != 2 {
+		t.Errorf("sent %d messages after flushing again, want still 2", len(mailer.Sent))
+	}
+}
+
+func TestOutboxRetries(t *testing.T) {
+	outbox, mailer := testOutbox(t)
+	mailer.Err = errors.New("421 try again later")
+	enqueue(t, outbox, "alice@mit.edu")
+	start := time.Now()
+	flush(t, outbox, 1)
+
+	path := onlyQueued(t, outbox)
+	q := readQueued(t, path)
+	if q.Attempts != 1 || q.LastError != "421 try again later" {
+		t.Errorf("after failing once, Attempts = %d and LastError = %q", q.Attempts, q.LastError)
+	}
+	if retry := q.NextAttempt.Sub(start); retry < time.Minute || retry > time.Minute+time.Second*10 {
+		t.Errorf("retrying %v later, want a minute", retry)
+	}
+
+	// Not due yet, so not tried again
+	flush(t, outbox, 1)
+	if q := readQueued(t, path); q.Attempts != 1 {
+		t.Errorf("tried again before it was due (%d attempts)", q.Attempts)
+	}
+
+	// Failing again, the wait doubles
+	requeue(t, outbox, path, func(q *queuedMessage) { q.NextAttempt = time.Now() })
+	start = time.Now()
+	flush(t, outbox, 1)
+	q = readQueued(t, path)
+	if retry := q.NextAttempt.Sub(start); q.Attempts != 2 || retry < 2*time.Minute || retry > 2*time.Minute+time.Second*10 {
+		t.Errorf("after failing twice, Attempts = %d, retrying %v later; want 2 and 2 minutes", q.Attempts, retry)
+	}
+
+	// And then it works
+	mailer.Err = nil
+	requeue(t, outbox, path, func(q *queuedMessage) { q.NextAttempt = time.Now() })
+	flush(t, outbox, 0)
+	if len(mailer.Sent) != 1 || len(queued(t, outbox)) != 0 {
+		t.Errorf("sent %v, leaving %v in the outbox; want it sent", mailer.Sent, queued(t, outbox))
+	}
+}
+
+func TestOutboxGivesUp(t *testing.T) {
+	outbox, mailer := testOutbox(t)
+	mailer.Err = errors.New("550 no such user")
+	enqueue(t, outbox, "nobody@mit.edu")
+	path := onlyQueued(t, outbox)
+	requeue(t, outbox, path, func(q *queuedMessage) { q.Attempts = maxSendAttempts - 1 })
+	// (and something which isn't a message at all)
+	if err := os.WriteFile(filepath.Join(outbox.Dir, "junk.json"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	flush(t, outbox, 0)
+
+	if left := queued(t, outbox); len(left) != 0 {
+		t.Errorf("left %v in the outbox", left)
+	}
+	q := readQueued(t, filepath.Join(outbox.Dir, "failed", filepath.Base(path)))
+	if q.Attempts != maxSendAttempts || q.LastError != "550 no such user" {
+		t.Errorf("failed/ has the message after %d attempts, with the error %q; want %d and the last error", q.Attempts, q.LastError, maxSendAttempts)
+	}
+	if _, err := os.Stat(filepath.Join(outbox.Dir, "failed", "junk.json")); err != nil {
+		t.Errorf("the unreadable file wasn't moved to failed/: %v", err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 5: 16 * time.Minute, 9: 256 * time.Minute, 10: 6 * time.Hour, maxSendAttempts: 6 * time.Hour} {
+		if got := retryDelay(attempts); got != want {
+			t.Errorf("retryDelay(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
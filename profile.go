@@ -0,0 +1,165 @@
+package mealplan
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/mail"
+	"os"
+
+	"github.com/pikans/mealplan/moira"
+)
+
+// default
+const ProfilesFile = "profiles.json"
+
+// What we know about a person beyond their username. Filled in from their certificate the first
+// time they log in, and editable by them afterwards.
+type Profile struct {
+	DisplayName   string      // full name, e.g. from the certificate
+	PreferredName string      // what they'd like to be called, if different
+	Email         moira.Email // where to send them mail
+	// How they want each kind of notification (see NotificationKinds), e.g. {"digest": "none"}.
+	// Kinds they haven't chosen a channel for come by email.
+	Notifications map[string]string
+	// Which reminders about their shifts they want, by how many days before the shift they're
+	// sent (the DaysBefore of the ReminderRules), e.g. [0] for only the day-of ones. All of them, if
+	// empty.
+	ReminderDaysBefore []int
+}
+
+// The kinds of notifications people can choose how (or whether) to get.
+const (
+	ReminderNotification = "reminder"
+	AbandonNotification  = "abandon"
+	DigestNotification   = "digest"
+	UnfilledNotification = "unfilled"
+)
+
+var NotificationKinds = []struct{ Kind, Description string }{
+	{ReminderNotification, "Reminders about shifts you've claimed"},
+	{AbandonNotification, "A copy of the email the kitchen manager gets when you abandon a shift"},
+	{DigestNotification, "The weekly digest of the coming week's schedule"},
+	{UnfilledNotification, "Alerts about important shifts nobody has claimed yet"},
+}
+
+// The ways notifications can be sent, or not.
+const (
+	EmailChannel = "email"
+	NoChannel    = "none"
+)
+
+var NotificationChannels = []string{EmailChannel, NoChannel}
+
+func ValidChannel(channel string) bool {
+	for _, valid := range NotificationChannels {
+		if channel == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// How the user wants to get the given kind of notification.
+func (p Profiles) Channel(u moira.Username, kind string) string {
+	if profile, ok := p[u]; ok {
+		if channel, ok := profile.Notifications[kind]; ok {
+			return channel
+		}
+	}
+	return EmailChannel
+}
+
+// Whether the user wants the given kind of notification by email.
+func (p Profiles) WantsEmail(u moira.Username, kind string) bool {
+	return p.Channel(u, kind) == EmailChannel
+}
+
+// Whether the user wants an email reminding them of their shift daysBefore days ahead of it.
+func (p Profiles) WantsReminder(u moira.Username, daysBefore int) bool {
+	if !p.WantsEmail(u, ReminderNotification) {
+		return false
+	}
+	profile, ok := p[u]
+	return !ok || profile.WantsReminderDaysBefore(daysBefore)
+}
+
+// Whether the reminders sent daysBefore days ahead of shifts are among the ones the user picked (if
+// they get reminders at all).
+func (p *Profile) WantsReminderDaysBefore(daysBefore int) bool {
+	if len(p.ReminderDaysBefore) == 0 {
+		return true
+	}
+	for _, days := range p.ReminderDaysBefore {
+		if days == daysBefore {
+			return true
+		}
+	}
+	return false
+}
+
+// All the profiles, by username.
+type Profiles map[moira.Username]*Profile
+
+// Read all the profiles from a file
+func ReadProfiles(profilesFile string) (Profiles, error) {
+	file, err := os.Open(profilesFile)
+	switch {
+	case os.IsNotExist(err):
+		// Doesn't exist: nobody has logged in yet
+		return Profiles{}, nil
+	case err != nil:
+		return nil, err
+	default:
+		defer file.Close()
+		jsonBytes, err := ioutil.ReadAll(file)
+		if err != nil {
+			return nil, err
+		}
+		profiles := Profiles{}
+		if err := json.Unmarshal(jsonBytes, &profiles); err != nil {
+			return nil, err
+		}
+		return profiles, nil
+	}
+}
+
+// Write all the profiles back to the file
+func WriteProfiles(profilesFile string, profiles Profiles) error {
+	jsonBytes, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomically(profilesFile, jsonBytes)
+}
+
+// The name to show for a user: their preferred name if they set one, otherwise their full name,
+// otherwise just the username.
+func (p Profiles) DisplayName(u moira.Username) string {
+	if profile, ok := p[u]; ok {
+		if profile.PreferredName != "" {
+			return profile.PreferredName
+		}
+		if profile.DisplayName != "" {
+			return profile.DisplayName
+		}
+	}
+	return string(u)
+}
+
+// The address to send a user mail at: their contact email if they set one, otherwise the one
+// implied by their username.
+func (p Profiles) Email(u moira.Username) moira.Email {
+	if profile, ok := p[u]; ok && profile.Email != "" {
+		return profile.Email
+	}
+	return u.Email()
+}
+
+// The user's address, with their name, for addressing email to them.
+func (p Profiles) Address(u moira.Username) mail.Address {
+	address := mail.Address{Address: string(p.Email(u))}
+	if name := p.DisplayName(u); name != string(u) {
+		address.Name = name
+	}
+	return address
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/mail"
+	"sort"
+	"strings"
+
+	. "github.com/pikans/mealplan"
+	"github.com/pikans/mealplan/moira"
+)
+
+// The unfilled-shift alerts are scheduled like reminders for a group named UnfilledAlertGroup, one
+// rule per entry in UnfilledAlerts.DaysBefore, so they're sent (and remembered as sent) the same way.
+func alertRules(config *Config) []ReminderRule {
+	if !config.UnfilledAlerts.Enabled {
+		return nil
+	}
+	rules := []ReminderRule{}
+	for _, days := range config.UnfilledAlerts.DaysBefore {
+		rules = append(rules, ReminderRule{Group: UnfilledAlertGroup, DaysBefore: days, At: config.UnfilledAlerts.At})
+	}
+	return rules
+}
+
+// The reminder rules plus the unfilled-shift alerts and posting tonight's crew.
+func allRules(config *Config) []ReminderRule {
+	rules := append([]ReminderRule{}, config.ReminderRules...)
+	rules = append(rules, alertRules(config)...)
+	return append(rules, crewRules(config)...)
+}
+
+// The important duties on the day which nobody has claimed (and which aren't closed), in the order
+// they appear on the signup sheet, with links to claim them.
+func openRequiredDuties(config *Config, data *Data, day string) []OpenDuty {
+	required := config.RequiredDuties()
+	open := []OpenDuty{}
+	for _, duty := range data.Duties {
+		if required[duty] && data.Assignments[day][duty] == "" {
+			open = append(open, OpenDuty{Duty: duty, ClaimURL: ClaimURL(config.BaseURL, "claim", duty, day)})
+		}
+	}
+	return open
+}
+
+// Everybody on the list of people who can sign up (Authorize) who wants the given kind of
+// notification by email, sorted. Most people never change the default, and plenty have never even
+// logged in and have no profile, so it's everybody but those who turned it off. If the list can't be
+// looked up, it makes do with everybody who has a profile.
+func subscribers(config *Config, profiles Profiles, kind string) []moira.Username {
+	members, err := config.Directory.Open().Members(config.Authorize)
+	if err != nil {
+		log.Printf("couldn't look up %s, so only sending %s to people who have logged in: %v", config.Authorize, kind, err)
+		members = nil
+		for username := range profiles {
+			members = append(members, username)
+		}
+	}
+	usernames := []string{}
+	for _, username := range members {
+		if profiles.WantsEmail(username, kind) {
+			usernames = append(usernames, string(username))
+		}
+	}
+	sort.Strings(usernames)
+	subscribed := []moira.Username{}
+	for _, u := range usernames {
+		subscribed = append(subscribed, moira.Username(u))
+	}
+	return subscribed
+}
+
+// If any important duties on the day (dayDelta days from today) are unclaimed, email the whole house
+// (everybody on the list who hasn't turned these alerts off) asking for somebody to take them, and post to the
+// webhook. The closer the day, the more urgent it sounds.
+func sendUnfilledAlert(config *Config, mailer Mailer, data *Data, profiles Profiles, day string, dayDelta int) {
+	open := openRequiredDuties(config, data, day)
+	to := subscribers(config, profiles, UnfilledNotification)
+	if *dryRun {
+		duties := []string{}
+		for _, o := range open {
+			duties = append(duties, o.Duty)
+		}
+		fmt.Printf("######## unfilled alert for %s to %v: open duties %v\n", day, to, duties)
+	}
+	if len(open) == 0 {
+		return
+	}
+	if config.Webhook.Wants(UnfilledEvent) {
+		links := []string{}
+		for _, o := range open {
+			links = append(links, fmt.Sprintf("[%s](%s)", o.Duty, o.ClaimURL))
+		}
+		urgency := ""
+		switch dayDelta {
+		case 0:
+			urgency = "**Dinner tonight will be canceled** unless somebody claims "
+		case 1:
+			urgency = "Dinner tomorrow may be canceled: nobody has claimed "
+		default:
+			urgency = fmt.Sprintf("Dinner on %s still needs ", LongDayName(day))
+		}
+		postWebhook(config, WebhookEvent{
+			Event: UnfilledEvent,
+			Text:  urgency + strings.Join(links, ", "),
+			Day:   day,
+			URL:   open[0].ClaimURL,
+		})
+	}
+	secret, replyTo := replySetup(config)
+	for _, username := range to {
+		// Everybody gets their own tokens for claiming by replying
+		theirOpen := append([]OpenDuty{}, open...)
+		for i := range theirOpen {
+			if secret != nil {
+				theirOpen[i].ClaimToken = ReplyToken{Action: "claim", Username: username, Day: day, Duty: theirOpen[i].Duty}.Sign(secret)
+			}
+		}
+		msg, err := NewReplyableEmail("unfilled", config.SMTP.FromAddress(), replyTo, []mail.Address{profiles.Address(username)}, nil, UnfilledEmail{
+			Day:        day,
+			DaysLeft:   dayDelta,
+			OpenDuties: theirOpen,
+			SignupURL:  config.BaseURL,
+		})
+		if err != nil {
+			log.Printf("couldn't compose unfilled alert for %s to %s: %v", day, username, err)
+			continue
+		}
+		if err := mailer.Send(msg); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+}
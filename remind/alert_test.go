@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/pikans/mealplan"
+	"github.com/pikans/mealplan/moira"
+)
+
+// A house with two people on the list, one of whom turned the alerts off, and a webhook which
+// posts to a local stand-in for the chat server.
+func alertTest(t *testing.T) (*Config, *Data, Profiles, *[]map[string]string) {
+	posted := []map[string]string{}
+	chat := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("the webhook posted something that isn't JSON: %v", err)
+		}
+		posted = append(posted, body)
+	}))
+	t.Cleanup(chat.Close)
+
+	config := DefaultConfig()
+	config.BaseURL = "https://mealplan.example.com"
+	config.SMTP.From = "mealplan@example.com"
+	config.Authorize = "house"
+	config.Directory = DirectoryConfig{Backend: "static", Lists: map[string][]moira.Username{"house": {"bob", "alice"}}}
+	config.ReminderGroups = map[string]ReminderGroup{"cook": {Duties: []string{"Big Cook"}, ImportantDuties: []string{"Big Cook"}}}
+	config.Webhook = WebhookConfig{URL: chat.URL, UnfilledAlerts: true}
+
+	data := &Data{
+		Assignments: map[string]map[string]moira.Username{"2026-10-21": {"Cleaner": "carol"}},
+		Duties:      []string{"Big Cook", "Cleaner"},
+		EndDate:     "2026-12-31",
+	}
+	profiles := Profiles{"alice": &Profile{Notifications: map[string]string{UnfilledNotification: NoChannel}}}
+	return config, data, profiles, &posted
+}
+
+func TestUnfilledAlert(t *testing.T) {
+	config, data, profiles, posted := alertTest(t)
+	mailer := &FakeMailer{}
+	sendUnfilledAlert(config, mailer, data, profiles, "2026-10-21", 0)
+
+	if len(mailer.Sent) != 1 {
+		t.Fatalf("sent %d emails, want 1 (to bob)", len(mailer.Sent))
+	}
+	msg := mailer.Sent[0]
+	if len(msg.To) != 1 || msg.To[0] != "bob@mit.edu" {
+		t.Errorf("sent to %v, want bob@mit.edu", msg.To)
+	}
+	body := string(msg.Body)
+	for _, want := range []string{"Subject: URGENT: dinner tonight will be canceled", "Big Cook", config.BaseURL + "/claim?"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("the email doesn't have %q in it:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "Cleaner") {
+		t.Errorf("the email asks for a duty which isn't important:\n%s", body)
+	}
+
+	if len(*posted) != 1 || !strings.Contains((*posted)[0]["text"], "Dinner tonight will be canceled") {
+		t.Errorf("posted %v to the webhook, want one unfilled alert", *posted)
+	}
+}
+
+func TestUnfilledAlertNothingOpen(t *testing.T) {
+	config, data, profiles, posted := alertTest(t)
+	data.Assignments["2026-10-21"]["Big Cook"] = "carol"
+	mailer := &FakeMailer{}
+	sendUnfilledAlert(config, mailer, data, profiles, "2026-10-21", 1)
+	if len(mailer.Sent) != 0 || len(*posted) != 0 {
+		t.Errorf("sent %d emails and posted %v with every important duty claimed", len(mailer.Sent), *posted)
+	}
+}
+
+func TestUnfilledAlertMailFails(t *testing.T) {
+	config, data, profiles, posted := alertTest(t)
+	config.Directory.Lists["house"] = []moira.Username{"alice", "bob", "dave"}
+	mailer := &FakeMailer{Err: errors.New("the mail server is down")}
+	sendUnfilledAlert(config, mailer, data, profiles, "2026-10-21", 3)
+	// (the failure is only logged; the chat still hears about it)
+	if len(mailer.Sent) != 0 || len(*posted) != 1 {
+		t.Errorf("sent %d emails and posted %v", len(mailer.Sent), *posted)
+	}
+}
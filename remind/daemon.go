@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	. "github.com/pikans/mealplan"
+)
+
+// Which reminders have been sent (see sentKey), and when, so that restarting the daemon doesn't
+// send them again. Stored in SentRemindersFile.
+type sentReminders map[string]time.Time
+
+// Identifies the reminder for one group's meal on one day under one rule.
+func sentKey(rule ReminderRule, day string) string {
+	return fmt.Sprintf("%s/%s/%d", rule.Group, day, rule.DaysBefore)
+}
+
+func readSentReminders(path string) (sentReminders, error) {
+	jsonBytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return sentReminders{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	sent := sentReminders{}
+	if err := json.Unmarshal(jsonBytes, &sent); err != nil {
+		return nil, err
+	}
+	return sent, nil
+}
+
+func writeSentReminders(path string, sent sentReminders) error {
+	jsonBytes, err := json.MarshalIndent(sent, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomically(path, jsonBytes)
+}
+
+// Reminders more than this late (because the daemon was down) aren't worth sending any more: the
+// meal is probably over, or a later reminder is about to go out anyway.
+const maxReminderLateness = 12 * time.Hour
+
+// A reminder which is due.
+type dueReminder struct {
+	rule ReminderRule
+	day  time.Time // the day of the meal
+	due  time.Time
+}
+
+// Find the reminders which were due by now but haven't been sent, for meals which haven't happened
+// yet. (If the daemon was down for a while, that can include reminders which should have gone out
+// earlier.) If several reminders for the same group and meal are due, only the latest should be sent,
+// so nobody gets reminded twice at once; the others, and any which are too late to bother with, are
+// returned as skip.
+func findDueReminders(rules []ReminderRule, sent sentReminders, now time.Time) (send, skip []dueReminder) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	latest := map[string]int{} // group/day -> index into send
+	for _, rule := range rules {
+		// The only meal this rule could be due for and which hasn't happened yet is within
+		// DaysBefore days from today
+		for delta := 0; delta <= rule.DaysBefore; delta++ {
+			day := today.AddDate(0, 0, delta)
+			due := rule.Due(day)
+			if due.After(now) {
+				continue
+			}
+			if _, ok := sent[sentKey(rule, day.Format(DateFormat))]; ok {
+				continue
+			}
+			reminder := dueReminder{rule, day, due}
+			if now.Sub(due) > maxReminderLateness {
+				skip = append(skip, reminder)
+				continue
+			}
+			meal := rule.Group + "/" + day.Format(DateFormat)
+			if i, ok := latest[meal]; !ok {
+				latest[meal] = len(send)
+				send = append(send, reminder)
+			} else if due.After(send[i].due) {
+				skip = append(skip, send[i])
+				send[i] = reminder
+			} else {
+				skip = append(skip, reminder)
+			}
+		}
+	}
+	return send, skip
+}
+
+// Send any reminders which are due, and record that they've been sent.
+func checkReminders(config *Config, mailer Mailer, now time.Time) error {
+	sent, err := readSentReminders(config.SentRemindersFile)
+	if err != nil {
+		return err
+	}
+	send, skip := findDueReminders(allRules(config), sent, now)
+	if len(send) == 0 && len(skip) == 0 {
+		return nil
+	}
+
+	data, err := ReadData(config.DataFile)
+	if err != nil {
+		return err
+	}
+	profiles, err := ReadProfiles(config.ProfilesFile)
+	if err != nil {
+		return err
+	}
+
+	for _, reminder := range send {
+		day := reminder.day.Format(DateFormat)
+		dayDelta := DaysBetween(now, reminder.day)
+		if now.Sub(reminder.due) > time.Hour {
+			log.Printf("catching up on the %s reminder for %s (was due %s)", reminder.rule.Group, day, reminder.due.Format(time.RFC1123))
+		}
+		remind(config, mailer, data, profiles, reminder.rule.Group, day, dayDelta)
+		sent[sentKey(reminder.rule, day)] = now
+	}
+	for _, reminder := range skip {
+		log.Printf("skipping the %s reminder for %s which was due %s, since it's too late or a later one is being sent", reminder.rule.Group, reminder.day.Format(DateFormat), reminder.due.Format(time.RFC1123))
+		sent[sentKey(reminder.rule, reminder.day.Format(DateFormat))] = now
+	}
+
+	// Forget about reminders for meals long past
+	for key, when := range sent {
+		if now.Sub(when) > 30*24*time.Hour {
+			delete(sent, key)
+		}
+	}
+	return writeSentReminders(config.SentRemindersFile, sent)
+}
+
+// Implements "remind daemon": checks for due reminders (and the digest) every minute until the
+// context is canceled.
+// Also keeps the outbox flushed, so reminders which fail to send get retried.
+func runDaemon(ctx context.Context, config *Config) error {
+	rules := allRules(config)
+	if len(rules) == 0 && config.Digest.Weekday == "" {
+		return fmt.Errorf("no ReminderRules, UnfilledAlerts, Digest or Webhook.CrewAt in the config file, so nothing to do")
+	}
+	outbox, err := config.Outbox()
+	if err != nil {
+		return err
+	}
+	go outbox.Run(ctx, time.Minute)
+
+	log.Printf("reminder daemon started with %d rules", len(rules))
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		if err := checkReminders(config, outbox, config.Now()); err != nil {
+			log.Printf("error checking reminders: %v", err)
+		}
+		if err := checkDigest(config, outbox, config.Now()); err != nil {
+			log.Printf("error sending the digest: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("reminder daemon stopping")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
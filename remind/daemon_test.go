@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	. "github.com/pikans/mealplan"
+)
+
+// The reminders, as sentKeys.
+func reminderKeys(reminders []dueReminder) []string {
+	keys := []string{}
+	for _, r := range reminders {
+		keys = append(keys, sentKey(r.rule, r.day.Format(DateFormat)))
+	}
+	return keys
+}
+
+func TestFindDueReminders(t *testing.T) {
+	config := DefaultConfig()
+	at := func(day, clock string) time.Time {
+		t2, err := time.ParseInLocation(DateFormat+" 15:04", day+" "+clock, config.Location())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return t2
+	}
+	// The default rules: cooks the day before at 10am and on the day at 2pm, cleaners at 5pm
+	rules := config.ReminderRules
+	for _, test := range []struct {
+		name       string
+		rules      []ReminderRule
+		sent       sentReminders
+		now        time.Time
+		send, skip []string
+	}{
+		{
+			name: "nothing due yet",
+			now:  at("2026-10-19", "09:59"),
+			sent: sentReminders{"cook/2026-10-19/1": at("2026-10-18", "10:00")},
+		},
+		{
+			name: "the cooks' reminders for tomorrow and today",
+			now:  at("2026-10-19", "15:00"),
+			sent: sentReminders{"cook/2026-10-19/1": at("2026-10-18", "10:00")},
+			send: []string{"cook/2026-10-20/1", "cook/2026-10-19/0"},
+		},
+		{
+			name: "already sent",
+			now:  at("2026-10-19", "15:00"),
+			sent: sentReminders{
+				"cook/2026-10-19/1": at("2026-10-18", "10:00"),
+				"cook/2026-10-20/1": at("2026-10-19", "10:00"),
+				"cook/2026-10-19/0": at("2026-10-19", "14:00"),
+			},
+		},
+		{
+			name: "down since yesterday morning, so yesterday's reminder is too late",
+			now:  at("2026-10-19", "17:30"),
+			send: []string{"cook/2026-10-20/1", "cook/2026-10-19/0", "clean/2026-10-19/0"},
+			skip: []string{"cook/2026-10-19/1"},
+		},
+		{
+			name:  "two due for the same meal, so only the later one is sent",
+			rules: []ReminderRule{{Group: "cook", DaysBefore: 1, At: "22:00"}, {Group: "cook", DaysBefore: 0, At: "07:00"}},
+			now:   at("2026-10-19", "08:00"),
+			send:  []string{"cook/2026-10-19/0"},
+			skip:  []string{"cook/2026-10-19/1"},
+		},
+		{
+			name: "across falling back (the day before is 25 hours long)",
+			now:  at("2026-11-01", "10:30"),
+			sent: sentReminders{"cook/2026-11-01/1": at("2026-10-31", "10:00")},
+			send: []string{"cook/2026-11-02/1"},
+		},
+	} {
+		if test.rules == nil {
+			test.rules = rules
+		}
+		if test.sent == nil {
+			test.sent = sentReminders{}
+		}
+		send, skip := findDueReminders(test.rules, test.sent, test.now)
+		if got := reminderKeys(send); !reflect.DeepEqual(got, append([]string{}, test.send...)) {
+			t.Errorf("%s: sending %q, want %q", test.name, got, test.send)
+		}
+		if got := reminderKeys(skip); !reflect.DeepEqual(got, append([]string{}, test.skip...)) {
+			t.Errorf("%s: skipping %q, want %q", test.name, got, test.skip)
+		}
+		for _, r := range send {
+			if r.due.After(test.now) || r.day.Location() != config.Location() {
+				t.Errorf("%s: sending %v, which isn't due until %v", test.name, r.rule, r.due)
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+
+	. "github.com/pikans/mealplan"
+	"github.com/pikans/mealplan/moira"
+)
+
+// The seven days the digest sent on the given day covers, starting the day after.
+func digestDays(sendDay time.Time) []string {
+	days := []string{}
+	for delta := 1; delta <= 7; delta++ {
+		days = append(days, sendDay.AddDate(0, 0, delta).Format(DateFormat))
+	}
+	return days
+}
+
+// The digest for one person.
+func makeDigest(config *Config, data *Data, profiles Profiles, days []string, username moira.Username) DigestEmail {
+	digest := DigestEmail{
+		Name:           profiles.DisplayName(username),
+		Duties:         data.Duties,
+		SignupURL:      config.BaseURL,
+		PreferencesURL: strings.TrimSuffix(config.BaseURL, "/") + "/me",
+	}
+	for _, day := range days {
+		digestDay := DigestDay{Day: day}
+		for _, duty := range data.Duties {
+			assignee := data.Assignments[day][duty]
+			s := DigestShift{
+				Duty:   duty,
+				Open:   assignee == "",
+				Closed: assignee == "_",
+				Mine:   assignee == username,
+			}
+			if s.Open {
+				s.ClaimURL = ClaimURL(config.BaseURL, "claim", duty, day)
+				digest.OpenCount++
+			} else if !s.Closed {
+				s.Name = profiles.DisplayName(assignee)
+			}
+			if s.Mine {
+				digest.MyShifts++
+			}
+			digestDay.Shifts = append(digestDay.Shifts, s)
+		}
+		digest.Days = append(digest.Days, digestDay)
+	}
+	return digest
+}
+
+// Send everybody on the list (except those who turned it off) the digest of the seven days after
+// sendDay.
+func sendDigest(config *Config, mailer Mailer, data *Data, profiles Profiles, sendDay time.Time) {
+	days := digestDays(sendDay)
+	to := subscribers(config, profiles, DigestNotification)
+	if *dryRun {
+		fmt.Printf("######## digest for %s to %s to: %v\n", days[0], days[len(days)-1], to)
+	}
+	for _, username := range to {
+		digest := makeDigest(config, data, profiles, days, username)
+		msg, err := NewEmail("digest", config.SMTP.FromAddress(), []mail.Address{profiles.Address(username)}, nil, digest)
+		if err != nil {
+			log.Printf("couldn't compose digest for %v: %v", username, err)
+			continue
+		}
+		if err := mailer.Send(msg); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+}
+
+// Identifies the digest sent on the given day in the sent reminders.
+func digestSentKey(sendDay time.Time) string {
+	return "digest/" + sendDay.Format(DateFormat)
+}
+
+// Send the digest if it's due and hasn't been sent yet, and record (in SentRemindersFile) that it
+// has. A digest more than maxReminderLateness late isn't sent at all.
+func checkDigest(config *Config, mailer Mailer, now time.Time) error {
+	if config.Digest.Weekday == "" {
+		return nil
+	}
+	sent, err := readSentReminders(config.SentRemindersFile)
+	if err != nil {
+		return err
+	}
+	due := config.Digest.LastDue(now)
+	if _, ok := sent[digestSentKey(due)]; ok {
+		return nil
+	}
+	if now.Sub(due) > maxReminderLateness {
+		log.Printf("skipping the digest which was due %s, since it's too late", due.Format(time.RFC1123))
+	} else {
+		data, err := ReadData(config.DataFile)
+		if err != nil {
+			return err
+		}
+		profiles, err := ReadProfiles(config.ProfilesFile)
+		if err != nil {
+			return err
+		}
+		sendDigest(config, mailer, data, profiles, due)
+	}
+	sent[digestSentKey(due)] = now
+	return writeSentReminders(config.SentRemindersFile, sent)
+}
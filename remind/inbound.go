@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/pikans/mealplan"
+)
+
+// The key reply tokens are signed with and where replies should go, or nil if replying isn't set up.
+func replySetup(config *Config) ([]byte, *mail.Address) {
+	if config.Inbound.Address == "" {
+		return nil, nil
+	}
+	secret, err := ReadOrCreateSecret(config.Inbound.SecretFile)
+	if err != nil {
+		log.Printf("couldn't read the reply token secret, so emails won't take replies: %v", err)
+		return nil, nil
+	}
+	return secret, &mail.Address{Name: "pika mealplan", Address: config.Inbound.Address}
+}
+
+// Don't answer vacation messages, bounces and the like, in case they answer back.
+func isAutomatic(header mail.Header) bool {
+	if auto := strings.ToLower(header.Get("Auto-Submitted")); auto != "" && auto != "no" {
+		return true
+	}
+	switch strings.ToLower(header.Get("Precedence")) {
+	case "bulk", "junk", "list", "auto_reply":
+		return true
+	}
+	return header.Get("X-Autoreply") != "" || header.Get("X-Autorespond") != ""
+}
+
+// The plain text part of a message (or of a part of one), decoded.
+func plainText(contentType, encoding string, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if contentType == "" || err != nil {
+		mediaType = "text/plain"
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return "", nil
+			} else if err != nil {
+				return "", err
+			}
+			// (NextPart takes care of quoted-printable itself)
+			text, err := plainText(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err != nil || text != "" {
+				return text, err
+			}
+		}
+	}
+	if mediaType != "text/plain" {
+		return "", nil
+	}
+	switch strings.ToLower(encoding) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	text, err := ioutil.ReadAll(body)
+	return string(text), err
+}
+
+// What the person wrote themselves in a reply: the first line of it, before the quoted email. Only
+// the first line counts, so that nothing further down (like a quote we didn't spot as one) can
+// abandon somebody's shift by accident.
+func newText(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, ">") || strings.HasPrefix(trimmed, "[mealplan:") {
+			continue
+		}
+		if startsQuote(trimmed, lines[i+1:]) {
+			return ""
+		}
+		return strings.ToLower(trimmed)
+	}
+	return ""
+}
+
+// Whether the line (followed by the rest) is where the quoted email starts: "On ... wrote:", or
+// the way Outlook does it, with "-----Original Message-----", a line of underscores, or a block of
+// headers ("From: ...", then "Sent: ..." or "Date: ...").
+func startsQuote(line string, rest []string) bool {
+	switch {
+	case strings.HasPrefix(line, "On ") && strings.HasSuffix(line, "wrote:"):
+		return true
+	case strings.HasPrefix(line, "-----Original Message"):
+		return true
+	case strings.HasPrefix(line, "____"):
+		return true
+	case strings.HasPrefix(line, "From:"):
+		if strings.Contains(line, "Sent:") {
+			return true
+		}
+		for i := 0; i < len(rest) && i < 3; i++ {
+			next := strings.TrimSpace(rest[i])
+			if strings.HasPrefix(next, "Sent:") || strings.HasPrefix(next, "Date:") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// What a reply asks for: "abandon", "claim", or "" if we can't tell.
+func replyAction(text string) string {
+	text = strings.ReplaceAll(text, "’", "'")
+	// (none of these are in the emails themselves, in case they're quoted)
+	for _, phrase := range []string{"abandon", "can't make it", "cant make it", "cannot make it", "can not make it", "can't do it", "cannot do it", "can't come", "won't make it"} {
+		if strings.Contains(text, phrase) {
+			return "abandon"
+		}
+	}
+	for _, phrase := range []string{"claim", "i'll do it", "i can do it", "sign me up", "i'll take", "i will take"} {
+		if strings.Contains(text, phrase) {
+			return "claim"
+		}
+	}
+	return ""
+}
+
+// Carry out a command emailed to Inbound.Address, if it's a reply with a genuine token, and reply to
+// say how it went.
+func handleReply(config *Config, mailer Mailer, secret []byte, r io.Reader) error {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return err
+	}
+	from := msg.Header.Get("From")
+	if isAutomatic(msg.Header) {
+		log.Printf("ignoring automatic email from %s", from)
+		return nil
+	}
+	text, err := plainText(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return fmt.Errorf("couldn't read email from %s: %v", from, err)
+	}
+	tokens := FindReplyTokens(secret, text)
+	if len(tokens) == 0 {
+		// Without a token we don't know who they are, so don't answer
+		log.Printf("ignoring email from %s with no reply token", from)
+		return nil
+	}
+	profiles, err := ReadProfiles(config.ProfilesFile)
+	if err != nil {
+		return err
+	}
+
+	written := newText(text)
+	action := replyAction(written)
+	candidates := []ReplyToken{}
+	for _, token := range tokens {
+		if token.Action == action {
+			candidates = append(candidates, token)
+		}
+	}
+	if len(candidates) > 1 {
+		// e.g. an alert about several open duties: which one do they want?
+		named := []ReplyToken{}
+		for _, token := range candidates {
+			if strings.Contains(written, strings.ToLower(token.Duty)) {
+				named = append(named, token)
+			}
+		}
+		candidates = named
+	}
+
+	result := ReplyResultEmail{Name: profiles.DisplayName(tokens[0].Username), Action: action, SignupURL: config.BaseURL}
+	var token ReplyToken
+	switch {
+	case action == "":
+		result.Problem = `I couldn't tell what you wanted. Reply saying "can't make it" to abandon your shift, or "claim" and the name of the duty to claim one, on the first line.`
+		token = tokens[0]
+	case len(candidates) != 1:
+		result.Problem = fmt.Sprintf(`I couldn't tell which duty you wanted to %s. Say which one in your reply, e.g. "%s %s".`, action, action, tokens[0].Duty)
+		token = tokens[0]
+	default:
+		token = candidates[0]
+		result.Duty, result.Day = token.Duty, token.Day
+		if err := carryOut(config, mailer, profiles, token); err != nil {
+			result.Problem = err.Error()
+		}
+	}
+	outcome := "done"
+	if result.Problem != "" {
+		outcome = "failed: " + result.Problem
+	}
+	log.Printf("reply from %s (%s): %s %s on %s: %s", from, token.Username, action, result.Duty, result.Day, outcome)
+
+	reply, err := NewEmail("reply", config.SMTP.FromAddress(), []mail.Address{profiles.Address(token.Username)}, nil, result)
+	if err != nil {
+		return err
+	}
+	return mailer.Send(reply)
+}
+
+// Claim or abandon the duty, the same way the signup page does.
+func carryOut(config *Config, mailer Mailer, profiles Profiles, token ReplyToken) error {
+	if token.Day < now(config).Format(DateFormat) {
+		return fmt.Errorf("%s has already passed.", LongDayName(token.Day))
+	}
+	if *dryRun {
+		fmt.Printf("######## would %s %s on %s for %s\n", token.Action, token.Duty, token.Day, token.Username)
+		return nil
+	}
+	err := Transact(config.DataFile, func(data *Data) error {
+		if token.Action == "claim" {
+			return Claim(data, token.Username, token.Duty, token.Day)
+		}
+		return Abandon(data, token.Username, token.Duty, token.Day)
+	})
+	if err != nil || token.Action != "abandon" {
+		return err
+	}
+
+	msg, err := NewAbandonEmail(config, profiles, token.Username, token.Duty, token.Day)
+	if err == nil {
+		err = mailer.Send(msg)
+	}
+	if err != nil {
+		log.Printf("couldn't send abandon email: %v", err)
+	}
+	if config.Webhook.Wants(AbandonEvent) {
+		postWebhook(config, AbandonWebhookEvent(config, profiles.DisplayName(token.Username), token.Duty, token.Day))
+	}
+	return nil
+}
+
+// Implements "remind inbound [<maildir>]": handles one email from stdin (e.g. piped in by procmail),
+// or every new email in a Maildir, moving each one to cur/ once it's been handled.
+func inbound(config *Config, maildir string) {
+	secret, _ := replySetup(config)
+	if secret == nil {
+		log.Fatal("replying isn't set up: Inbound.Address is empty, or the secret can't be read")
+	}
+	mailer, outbox := getMailer(config)
+	defer flushNow(outbox)
+
+	if maildir == "" {
+		input, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := handleReply(config, mailer, secret, bytes.NewReader(input)); err != nil {
+			log.Printf("%v", err)
+		}
+		return
+	}
+
+	paths, err := filepath.Glob(filepath.Join(maildir, "new", "*"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Printf("%v", err)
+			continue
+		}
+		if err := handleReply(config, mailer, secret, file); err != nil {
+			log.Printf("%s: %v", path, err)
+		}
+		file.Close()
+		if *dryRun {
+			continue
+		}
+		// Mark it as seen, so it's only handled once
+		if err := os.Rename(path, filepath.Join(maildir, "cur", filepath.Base(path)+":2,S")); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+}
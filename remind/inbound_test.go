@@ -0,0 +1,148 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/pikans/mealplan"
+	"github.com/pikans/mealplan/moira"
+)
+
+func TestNewText(t *testing.T) {
+	for _, test := range []struct {
+		reply string
+		want  string
+	}{
+		{"Can't make it, sorry!\n\nOn Mon, Oct 19, 2026 at 10:00 AM pika mealplan wrote:\n> claim", "can't make it, sorry!"},
+		{"\n\n  I'll take Big Cook  \nthanks\n", "i'll take big cook"},
+		{"[mealplan:abc.def]\nclaim", "claim"},
+		{"> claim\n>\nsure", "sure"},
+		// Nothing written, just the quote, in all the ways it can start
+		{"On Mon, Oct 19, 2026 at 10:00 AM pika mealplan <mealplan@example.com> wrote:\n> To claim it, reply \"claim\"", ""},
+		{"-----Original Message-----\nFrom: pika mealplan\nclaim", ""},
+		{"________________________________\nFrom: pika mealplan\nclaim", ""},
+		{"From: pika mealplan <mealplan@example.com>\nSent: Monday, October 19, 2026 10:00 AM\nTo: Alice\nclaim", ""},
+		{"From: pika mealplan <mealplan@example.com> Sent: Monday, October 19, 2026 10:00 AM\nclaim", ""},
+		{"From: pika mealplan <mealplan@example.com>\nTo: Alice\nDate: Monday, October 19, 2026 10:00 AM\nclaim", ""},
+		// Not a quote, just somebody starting with "From:"
+		{"From: Alice. I can do it\n\nnothing else", "from: alice. i can do it"},
+		{"", ""},
+	} {
+		if got := newText(test.reply); got != test.want {
+			t.Errorf("newText(%q) = %q, want %q", test.reply, got, test.want)
+		}
+	}
+}
+
+func TestReplyAction(t *testing.T) {
+	for text, want := range map[string]string{
+		"can’t make it, sorry":     "abandon",
+		"i won't make it tonight":  "abandon",
+		"please abandon":           "abandon",
+		"claim":                    "claim",
+		"i'll take little cook":    "claim",
+		"sure, sign me up":         "claim",
+		"thanks for the reminder!": "",
+		"":                         "",
+	} {
+		if got := replyAction(text); got != want {
+			t.Errorf("replyAction(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+// A house where alice got an alert about both cooks being open on the 21st.
+func replyTest(t *testing.T) (*Config, []byte, string) {
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.BaseURL = "https://mealplan.example.com"
+	config.DataFile = filepath.Join(dir, "data.json")
+	config.ProfilesFile = filepath.Join(dir, "profiles.json")
+	config.Inbound.Address = "mealplan-replies@example.com"
+	data := &Data{
+		Assignments: map[string]map[string]moira.Username{"2026-10-21": {"Cleaner": "carol"}},
+		Duties:      []string{"Big Cook", "Little Cook", "Cleaner"},
+		EndDate:     "2026-12-31",
+	}
+	if err := WriteData(config.DataFile, data); err != nil {
+		t.Fatal(err)
+	}
+	*simulatedDate = "2026-10-19"
+	t.Cleanup(func() { *simulatedDate = "" })
+
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	tokens := ""
+	for _, duty := range []string{"Big Cook", "Little Cook"} {
+		tokens += "> " + duty + ": " + ReplyToken{Action: "claim", Username: "alice", Day: "2026-10-21", Duty: duty}.Sign(secret) + "\n"
+	}
+	return config, secret, tokens
+}
+
+func reply(t *testing.T, config *Config, secret []byte, body string) *FakeMailer {
+	mailer := &FakeMailer{}
+	email := "From: Alice <alice@mit.edu>\r\nTo: mealplan-replies@example.com\r\nSubject: Re: Dinner needs cooks\r\n\r\n" + body
+	if err := handleReply(config, mailer, secret, strings.NewReader(email)); err != nil {
+		t.Fatal(err)
+	}
+	return mailer
+}
+
+func assignments(t *testing.T, config *Config) map[string]moira.Username {
+	data, err := ReadData(config.DataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data.Assignments["2026-10-21"]
+}
+
+func TestHandleReplyClaims(t *testing.T) {
+	config, secret, tokens := replyTest(t)
+	mailer := reply(t, config, secret, "I'll take little cook!\n\nOn Monday, pika mealplan wrote:\n"+tokens)
+
+	if got := assignments(t, config); got["Little Cook"] != "alice" || got["Big Cook"] != "" {
+		t.Errorf("the 21st is now %v, want alice on Little Cook", got)
+	}
+	if len(mailer.Sent) != 1 || mailer.Sent[0].To[0] != "alice@mit.edu" {
+		t.Fatalf("sent %v, want a reply to alice", mailer.Sent)
+	}
+	if body := string(mailer.Sent[0].Body); strings.Contains(body, "couldn't") {
+		t.Errorf("the reply says something went wrong:\n%s", body)
+	}
+}
+
+func TestHandleReplyUnclear(t *testing.T) {
+	for _, body := range []string{
+		// Which one?
+		"claim\n\n" + "On Monday, pika mealplan wrote:\n",
+		// Nothing asked for, except in the quote
+		"Thanks!\n\n-----Original Message-----\nI'll take Big Cook\n",
+	} {
+		config, secret, tokens := replyTest(t)
+		mailer := reply(t, config, secret, body+tokens)
+		if got := assignments(t, config); got["Big Cook"] != "" || got["Little Cook"] != "" {
+			t.Errorf("replying %q claimed something: %v", body, got)
+		}
+		if len(mailer.Sent) != 1 || !strings.Contains(string(mailer.Sent[0].Body), "couldn't tell") {
+			t.Errorf("replying %q, sent %v; want a reply saying it couldn't tell what they wanted", body, mailer.Sent)
+		}
+	}
+}
+
+func TestHandleReplyIgnores(t *testing.T) {
+	config, secret, tokens := replyTest(t)
+	// No genuine token, so we don't know who it's from
+	forged := ReplyToken{Action: "claim", Username: "mallory", Day: "2026-10-21", Duty: "Big Cook"}.Sign([]byte("a secret mallory made up"))
+	if mailer := reply(t, config, secret, "claim big cook\n"+forged); len(mailer.Sent) != 0 {
+		t.Errorf("answered a reply without a genuine token: %v", mailer.Sent)
+	}
+
+	mailer := &FakeMailer{}
+	email := "From: Alice <alice@mit.edu>\r\nAuto-Submitted: auto-replied\r\nSubject: Out of office\r\n\r\nclaim big cook\n" + tokens
+	if err := handleReply(config, mailer, secret, strings.NewReader(email)); err != nil {
+		t.Fatal(err)
+	}
+	if len(mailer.Sent) != 0 || assignments(t, config)["Big Cook"] != "" {
+		t.Errorf("acted on an automatic reply")
+	}
+}
@@ -1,28 +1,23 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
-	"net/smtp"
+	"net/mail"
 	"os"
+	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	. "github.com/pikans/mealplan"
+	"github.com/pikans/mealplan/moira"
 )
 
-type ReminderGroup struct {
-	Duties          []string
-	ImportantDuties []string
-	TodayText       string
-}
-
-var DutyGroups = map[string]ReminderGroup{
-	"cook":  ReminderGroup{[]string{"Big Cook", "Little Cook", "Tiny Cook"}, []string{"Big Cook", "Little Cook"}, "today"},
-	"clean": ReminderGroup{[]string{"Cleaner 1", "Cleaner 2", "Cleaner 3"}, []string{"Cleaner 1", "Cleaner 2"}, "tonight"},
-}
-
 func dayDeltaString(dayDelta int, todayText string) string {
 	switch {
 	case dayDelta == 0:
@@ -40,31 +35,64 @@ func dayDeltaString(dayDelta int, todayText string) string {
 	}
 }
 
-const mailserver = "outgoing.mit.edu:smtp"
-const from = "yfnkm@mit.edu"
-
-func sendReminder(to []string, task string, mightBeCanceled bool) {
-	msg :=
-		`From: "pika kitchen manager" <%s>
-To: %s
-Subject: Reminder: you are signed up to %s
-
-http://mealplan.pikans.org/
+// A duty and the person who claimed it.
+type shift struct {
+	Duty     string
+	Assignee moira.Username
+}
 
-	`
-	if mightBeCanceled {
-		msg += "NOTE: not all shifts are filled, so dinner may be canceled"
+// Send each person on the given shifts (who wants a reminder dayDelta days ahead) their own reminder,
+// which also says who else is on the shift and (if dinner might be canceled) which important duties
+// are still open.
+func sendReminder(config *Config, mailer Mailer, profiles Profiles, data *Data, day string, dayDelta int, group ReminderGroup, shifts []shift, task string) {
+	canceled := mightBeCanceled(data, day, group)
+	openDuties := []OpenDuty{}
+	if canceled {
+		for _, duty := range group.ImportantDuties {
+			if data.Assignments[day][duty] == "" {
+				openDuties = append(openDuties, OpenDuty{Duty: duty, ClaimURL: ClaimURL(config.BaseURL, "claim", duty, day)})
+			}
+		}
 	}
-	body := fmt.Sprintf(msg, from, strings.Join(to, ", "), task)
-	to = append(to, from) // bcc yfnkm
-	err := smtp.SendMail(mailserver, nil, from, to, []byte(body))
-	if err != nil {
-		log.Printf("%v", err)
+	secret, replyTo := replySetup(config)
+	for _, s := range shifts {
+		if !profiles.WantsReminder(s.Assignee, dayDelta) {
+			continue
+		}
+		teammates := []Teammate{}
+		for _, other := range shifts {
+			if other != s {
+				teammates = append(teammates, Teammate{Name: profiles.DisplayName(other.Assignee), Duty: other.Duty})
+			}
+		}
+		abandonToken := ""
+		if secret != nil {
+			abandonToken = ReplyToken{Action: "abandon", Username: s.Assignee, Day: day, Duty: s.Duty}.Sign(secret)
+		}
+		msg, err := NewReplyableEmail("reminder", config.SMTP.FromAddress(), replyTo, []mail.Address{profiles.Address(s.Assignee)}, nil, ReminderEmail{
+			Name:            profiles.DisplayName(s.Assignee),
+			Task:            task,
+			Day:             day,
+			Duty:            s.Duty,
+			Description:     config.DutyDescriptions[s.Duty],
+			Teammates:       teammates,
+			MightBeCanceled: canceled,
+			OpenDuties:      openDuties,
+			AbandonURL:      ClaimURL(config.BaseURL, "abandon", s.Duty, day),
+			AbandonToken:    abandonToken,
+			SignupURL:       config.BaseURL,
+		})
+		if err != nil {
+			log.Printf("couldn't compose reminder for %v: %v", s.Assignee, err)
+			continue
+		}
+		msg.To = append(msg.To, config.SMTP.From) // bcc yfnkm
+		if err := mailer.Send(msg); err != nil {
+			log.Printf("%v", err)
+		}
 	}
 }
 
-
-
 // Returns whether any shifts are missing
 func mightBeCanceled(data *Data, day string, group ReminderGroup) bool {
 	dayAssignments, ok := data.Assignments[day]
@@ -80,49 +108,228 @@ func mightBeCanceled(data *Data, day string, group ReminderGroup) bool {
 	return false
 }
 
-func toEmail(username string) string {
-	if strings.Contains(username, "@") {
-		return username
-	} else {
-		return username + "@mit.edu"
+// Remind everybody signed up for the group's duties on the day, which is dayDelta days from today.
+func remindGroup(config *Config, mailer Mailer, data *Data, profiles Profiles, task string, group ReminderGroup, day string, dayDelta int) {
+	shifts := []shift{}
+	dayAssignments, ok := data.Assignments[day]
+	if ok {
+		for _, duty := range group.Duties {
+			assignee, ok := dayAssignments[duty]
+			if ok && string(assignee) != "" && string(assignee) != "_" {
+				shifts = append(shifts, shift{duty, assignee})
+			}
+		}
+	}
+	taskText := fmt.Sprintf("%s %s", task, dayDeltaString(dayDelta, group.TodayText))
+	if *dryRun {
+		names := []string{"nobody"}
+		if len(shifts) != 0 {
+			names = nil
+		}
+		for _, s := range shifts {
+			name := fmt.Sprintf("%s (%s)", s.Assignee, s.Duty)
+			if !profiles.WantsReminder(s.Assignee, dayDelta) {
+				name += " [doesn't want this reminder]"
+			}
+			names = append(names, name)
+		}
+		fmt.Printf("######## %s reminder for %s (%q): to %s; may be canceled: %v\n", task, day, taskText, strings.Join(names, ", "), mightBeCanceled(data, day, group))
+	}
+	sendReminder(config, mailer, profiles, data, day, dayDelta, group, shifts, taskText)
+}
+
+// Send whatever is scheduled for the group (as in a ReminderRule) for the day, which is dayDelta days
+// from today: the group's reminders, or an unfilled-shift alert, or tonight's crew.
+func remind(config *Config, mailer Mailer, data *Data, profiles Profiles, group string, day string, dayDelta int) {
+	switch group {
+	case UnfilledAlertGroup:
+		sendUnfilledAlert(config, mailer, data, profiles, day, dayDelta)
+	case CrewWebhookGroup:
+		postCrew(config, data, profiles, day)
+	default:
+		remindGroup(config, mailer, data, profiles, group, config.ReminderGroups[group], day, dayDelta)
 	}
 }
 
+var configFile = flag.String("config", ConfigFile, "path to the JSON configuration file (see mealplan-config.example.json)")
+var dryRun = flag.Bool("dry-run", false, "print the reminders instead of sending them")
+var simulatedDate = flag.String("date", "", "(YYYY-MM-DD) act as if today were this day instead")
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: %[1]s [flags] <group> <daysOut>
+           send reminders now for the group's duties daysOut days from today
+       %[1]s [flags] unfilled <daysOut>
+           email everybody now if important duties daysOut days from today are unclaimed
+       %[1]s [flags] crew 0
+           post who's on duty today to the chat webhook now
+       %[1]s [flags] webhook <message>
+           post a message to the chat webhook, to try it out
+       %[1]s [flags] inbound [<maildir>]
+           claim or abandon duties as asked in a reply to an email (read from stdin, or every new
+           message in the maildir)
+       %[1]s [flags] digest
+           send everybody the digest of the seven days starting tomorrow now
+       %[1]s [flags] daemon
+           keep running, sending reminders according to ReminderRules (and UnfilledAlerts and
+           Digest) in the config file
+       %[1]s -dry-run [-date YYYY-MM-DD] daemon
+           print all the reminders the ReminderRules would send today (or on -date)
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+// The current time in the house's time zone, or the same time of day on the -date day.
+func now(config *Config) time.Time {
+	t := config.Now()
+	if *simulatedDate == "" {
+		return t
+	}
+	date, err := config.ParseDay(*simulatedDate)
+	if err != nil {
+		log.Fatalf("invalid -date %q, please provide a date in YYYY-MM-DD format", *simulatedDate)
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+}
+
 func main() {
-	if len(os.Args) != 4 {
-		log.Fatalf("wrong number of args: run %v <datapath> <duty> <daysOut>", os.Args[0])
+	flag.Usage = usage
+	flag.Parse()
+
+	config, err := ReadConfig(*configFile)
+	if err != nil {
+		log.Fatal(err)
 	}
-	var ok bool
-	var err error
-	var group ReminderGroup
-	var dayDelta int
 
-	task := os.Args[2]
-	if group, ok = DutyGroups[task]; !ok {
+	switch {
+	case flag.NArg() == 1 && flag.Arg(0) == "daemon" && *dryRun:
+		previewDay(config, now(config))
+	case flag.NArg() == 1 && flag.Arg(0) == "daemon":
+		if *simulatedDate != "" {
+			log.Fatal("-date only works with -dry-run for the daemon")
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := runDaemon(ctx, config); err != nil {
+			log.Fatal(err)
+		}
+	case flag.NArg() == 2 && flag.Arg(0) == "webhook":
+		if config.Webhook.URL == "" {
+			log.Fatal("no Webhook.URL in the config file")
+		}
+		postWebhook(config, WebhookEvent{Event: "test", Text: flag.Arg(1), URL: config.BaseURL})
+	case flag.NArg() == 1 && flag.Arg(0) == "inbound":
+		inbound(config, "")
+	case flag.NArg() == 2 && flag.Arg(0) == "inbound":
+		inbound(config, flag.Arg(1))
+	case flag.NArg() == 1 && flag.Arg(0) == "digest":
+		digestNow(config)
+	case flag.NArg() == 2:
+		remindNow(config, flag.Arg(0), flag.Arg(1))
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// Where to send email: into the outbox, or with -dry-run, to stdout.
+func getMailer(config *Config) (Mailer, *Outbox) {
+	if *dryRun {
+		return WriterMailer{W: os.Stdout}, nil
+	}
+	outbox, err := config.Outbox()
+	if err != nil {
+		log.Fatalf("couldn't open outbox: %v", err)
+	}
+	return outbox, outbox
+}
+
+// Implements "remind <group> <daysOut>" (and "remind unfilled <daysOut>" and "remind crew 0").
+func remindNow(config *Config, task, daysOut string) {
+	_, ok := config.ReminderGroups[task]
+	if !ok && task != UnfilledAlertGroup && task != CrewWebhookGroup {
 		log.Fatalf("no task '%s'", task)
 	}
+	if task == UnfilledAlertGroup && !config.UnfilledAlerts.Enabled {
+		log.Fatalf("UnfilledAlerts aren't enabled in the config file")
+	}
+	if task == CrewWebhookGroup && config.Webhook.URL == "" {
+		log.Fatalf("no Webhook.URL in the config file")
+	}
 
-	if dayDelta, err = strconv.Atoi(os.Args[3]); err != nil {
-		log.Fatalf("invalid day delta '%s': %v", os.Args[2], err)
+	dayDelta, err := strconv.Atoi(daysOut)
+	if err != nil {
+		log.Fatalf("invalid day delta '%s': %v", daysOut, err)
 	}
-	
-	data, err := ReadData(os.Args[1])
+
+	data, err := ReadData(config.DataFile)
 	if err != nil {
-		log.Fatalf("couldn't read data from '%s': %v", os.Args[1], err)
+		log.Fatalf("couldn't read data from '%s': %v", config.DataFile, err)
 	}
 
-	to := []string{}
+	profiles, err := ReadProfiles(config.ProfilesFile)
+	if err != nil {
+		log.Fatalf("couldn't read profiles: %v", err)
+	}
 
-	day := time.Now().AddDate(0, 0, dayDelta).Format(DateFormat)
-	dayAssignments, ok := data.Assignments[day]
-	if ok {
-		for _, duty := range group.Duties {
-			assignee, ok := dayAssignments[duty]
-			if ok && string(assignee) != "" && string(assignee) != "_" {
-				to = append(to, toEmail(string(assignee)))
-			}
+	mailer, outbox := getMailer(config)
+	day := now(config).AddDate(0, 0, dayDelta).Format(DateFormat)
+	remind(config, mailer, data, profiles, task, day, dayDelta)
+	flushNow(outbox)
+}
+
+// Implements "remind digest".
+func digestNow(config *Config) {
+	data, err := ReadData(config.DataFile)
+	if err != nil {
+		log.Fatalf("couldn't read data from '%s': %v", config.DataFile, err)
+	}
+	profiles, err := ReadProfiles(config.ProfilesFile)
+	if err != nil {
+		log.Fatalf("couldn't read profiles: %v", err)
+	}
+	mailer, outbox := getMailer(config)
+	sendDigest(config, mailer, data, profiles, now(config))
+	flushNow(outbox)
+}
+
+// Try to send what's in the outbox right away. If that fails, it stays in the outbox, and the server
+// (or the next run of remind) will retry it.
+func flushNow(outbox *Outbox) {
+	if outbox == nil {
+		return
+	}
+	if waiting, err := outbox.Flush(); err != nil {
+		log.Printf("couldn't flush outbox: %v", err)
+	} else if waiting != 0 {
+		log.Printf("%d messages waiting in the outbox to be retried", waiting)
+	}
+}
+
+// Implements "remind -dry-run daemon": prints every reminder the rules would send on the given day,
+// in order, as if each were sent on time, and then the digest if that's the day for it.
+func previewDay(config *Config, date time.Time) {
+	data, err := ReadData(config.DataFile)
+	if err != nil {
+		log.Fatalf("couldn't read data from '%s': %v", config.DataFile, err)
+	}
+	profiles, err := ReadProfiles(config.ProfilesFile)
+	if err != nil {
+		log.Fatalf("couldn't read profiles: %v", err)
+	}
+
+	rules := allRules(config)
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].At < rules[j].At })
+	for _, rule := range rules {
+		meal := date.AddDate(0, 0, rule.DaysBefore)
+		fmt.Printf("######## at %s:\n", rule.Due(meal).Format("Mon Jan 2 15:04"))
+		remind(config, WriterMailer{W: os.Stdout}, data, profiles, rule.Group, meal.Format(DateFormat), rule.DaysBefore)
+	}
+	if config.Digest.Weekday != "" {
+		// The digest is due today if the last time it's due by the end of today is today
+		due := config.Digest.LastDue(time.Date(date.Year(), date.Month(), date.Day(), 23, 59, 0, 0, date.Location()))
+		if due.Format(DateFormat) == date.Format(DateFormat) {
+			fmt.Printf("######## at %s:\n", due.Format("Mon Jan 2 15:04"))
+			sendDigest(config, WriterMailer{W: os.Stdout}, data, profiles, due)
 		}
 	}
-	taskText := fmt.Sprintf("%s %s", task, dayDeltaString(dayDelta, group.TodayText))
-	sendReminder(to, taskText, mightBeCanceled(data, day, group))
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	. "github.com/pikans/mealplan"
+)
+
+// Tonight's crew is posted like a reminder for CrewWebhookGroup on the day itself, at Webhook.CrewAt.
+func crewRules(config *Config) []ReminderRule {
+	if !config.Webhook.Wants(CrewEvent) {
+		return nil
+	}
+	return []ReminderRule{{Group: CrewWebhookGroup, DaysBefore: 0, At: config.Webhook.CrewAt}}
+}
+
+// Post the event to the chat webhook, or with -dry-run, print what would be posted.
+func postWebhook(config *Config, event WebhookEvent) {
+	if *dryRun {
+		body, err := config.Webhook.Body(event)
+		if err != nil {
+			log.Printf("%v", err)
+			return
+		}
+		fmt.Printf("######## would post to %s:\n%s\n", config.Webhook.URL, body)
+		return
+	}
+	if err := config.Webhook.Post(event); err != nil {
+		log.Printf("couldn't post %s to the webhook: %v", event.Event, err)
+	}
+}
+
+// Post who's signed up for what on the day.
+func postCrew(config *Config, data *Data, profiles Profiles, day string) {
+	lines := []string{}
+	for _, duty := range data.Duties {
+		assignee := data.Assignments[day][duty]
+		switch assignee {
+		case "_":
+		case "":
+			lines = append(lines, fmt.Sprintf("* %s: **nobody!** [claim it](%s)", duty, ClaimURL(config.BaseURL, "claim", duty, day)))
+		default:
+			lines = append(lines, fmt.Sprintf("* %s: %s", duty, profiles.DisplayName(assignee)))
+		}
+	}
+	if len(lines) == 0 {
+		// Everything's closed, so there's no dinner
+		return
+	}
+	postWebhook(config, WebhookEvent{
+		Event: CrewEvent,
+		Text:  fmt.Sprintf("Tonight's crew (%s):\n%s", LongDayName(day), strings.Join(lines, "\n")),
+		Day:   day,
+		URL:   config.BaseURL,
+	})
+}
@@ -0,0 +1,106 @@
+package mealplan
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+
+	"github.com/pikans/mealplan/moira"
+)
+
+// Emails about a duty carry a reply token, which lets whoever they were sent to claim or abandon the
+// duty by replying (see remind/inbound.go). The token is signed, so nobody can make one up.
+type ReplyToken struct {
+	Action   string // "claim" or "abandon"
+	Username moira.Username
+	Day      string
+	Duty     string
+}
+
+var tokenEncoding = base64.RawURLEncoding
+
+// The token as it appears in emails, e.g. "[mealplan:WyJhYmFuZG9uIiwi...]".
+func (t ReplyToken) Sign(secret []byte) string {
+	payload, err := json.Marshal([]string{t.Action, string(t.Username), t.Day, t.Duty})
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("[mealplan:%s.%s]", tokenEncoding.EncodeToString(payload), tokenEncoding.EncodeToString(tokenMAC(secret, payload)))
+}
+
+func tokenMAC(secret, payload []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	// Half of it is plenty, and keeps the token short enough not to get wrapped in replies
+	return mac.Sum(nil)[:16]
+}
+
+var tokenRegexp = regexp.MustCompile(`\[mealplan:([A-Za-z0-9_-]+)\.([A-Za-z0-9_-]+)\]`)
+
+var errBadToken = errors.New("invalid or forged reply token")
+
+func parseReplyToken(secret []byte, payloadText, macText string) (ReplyToken, error) {
+	payload, err := tokenEncoding.DecodeString(payloadText)
+	if err != nil {
+		return ReplyToken{}, errBadToken
+	}
+	mac, err := tokenEncoding.DecodeString(macText)
+	if err != nil || !hmac.Equal(mac, tokenMAC(secret, payload)) {
+		return ReplyToken{}, errBadToken
+	}
+	var fields []string
+	if err := json.Unmarshal(payload, &fields); err != nil || len(fields) != 4 {
+		return ReplyToken{}, errBadToken
+	}
+	return ReplyToken{Action: fields[0], Username: moira.Username(fields[1]), Day: fields[2], Duty: fields[3]}, nil
+}
+
+// All the genuine reply tokens in some text (e.g. a reply quoting the original email), without
+// duplicates.
+func FindReplyTokens(secret []byte, text string) []ReplyToken {
+	tokens := []ReplyToken{}
+	seen := map[ReplyToken]bool{}
+	for _, match := range tokenRegexp.FindAllStringSubmatch(text, -1) {
+		token, err := parseReplyToken(secret, match[1], match[2])
+		if err == nil && !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// Read the key reply tokens are signed with, making up a new one if there isn't one yet.
+func ReadOrCreateSecret(path string) ([]byte, error) {
+	secret, err := ioutil.ReadFile(path)
+	if err == nil {
+		if len(secret) < 16 {
+			return nil, fmt.Errorf("the secret in %s is too short", path)
+		}
+		return secret, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	secret = make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	// Not WriteFileAtomically, which makes the file readable by everybody
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(secret); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return secret, file.Close()
+}
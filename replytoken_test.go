@@ -0,0 +1,66 @@
+package mealplan
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestReplyTokens(t *testing.T) {
+	claim := ReplyToken{Action: "claim", Username: "alice", Day: "2026-10-21", Duty: "Big Cook"}
+	abandon := ReplyToken{Action: "abandon", Username: "alice", Day: "2026-10-22", Duty: "Cleaner [2]"}
+	signed := claim.Sign(testSecret)
+	if !strings.HasPrefix(signed, "[mealplan:") || strings.ContainsAny(signed, " \n") {
+		t.Errorf("the token is %q", signed)
+	}
+
+	// A reply quoting the email, with each token in it twice (once wrapped in "> ")
+	text := "Sorry, can't make it\n\n> To abandon: " + abandon.Sign(testSecret) + "\n> " + signed + "\n" + signed + "\n" + abandon.Sign(testSecret)
+	if got, want := FindReplyTokens(testSecret, text), []ReplyToken{abandon, claim}; !reflect.DeepEqual(got, want) {
+		t.Errorf("found %v, want %v", got, want)
+	}
+
+	// Forgeries
+	payload, mac, _ := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(signed, "[mealplan:"), "]"), ".")
+	bob := ReplyToken{Action: "claim", Username: "bob", Day: "2026-10-21", Duty: "Big Cook"}.Sign(testSecret)
+	bobPayload, _, _ := strings.Cut(strings.TrimPrefix(bob, "[mealplan:"), ".")
+	for _, forged := range []string{
+		claim.Sign([]byte("somebody else's secret, perhaps")),
+		"[mealplan:" + bobPayload + "." + mac + "]",
+		"[mealplan:" + payload + "." + mac[:len(mac)-2] + "]",
+		"[mealplan:" + payload + "x." + mac + "]",
+		"[mealplan:" + payload + "]",
+		"[mealplan:.]",
+	} {
+		if got := FindReplyTokens(testSecret, "claim "+forged); len(got) != 0 {
+			t.Errorf("%q was taken for %v", forged, got)
+		}
+	}
+}
+
+func TestReadOrCreateSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reply-secret")
+	secret, err := ReadOrCreateSecret(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("the secret file is %v (%v), want it only readable by its owner", info.Mode(), err)
+	}
+	again, err := ReadOrCreateSecret(path)
+	if err != nil || !bytes.Equal(again, secret) || len(secret) < 16 {
+		t.Errorf("read the secret back as %x (%v), want %x", again, err, secret)
+	}
+
+	if err := os.WriteFile(path, []byte("short"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadOrCreateSecret(path); err == nil {
+		t.Error("a 5 byte secret is fine, apparently")
+	}
+}
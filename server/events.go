@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	. "github.com/pikans/mealplan"
+	"github.com/pikans/mealplan/moira"
+)
+
+// Live updates for the signup page: /events streams every change to the assignments as it happens
+// (as Server-Sent Events), so when everybody is claiming at once, the page can show which cells have
+// been taken without reloading. Changes are found by comparing the data file with what it was the
+// last time, after every transact and every few seconds (to catch changes by mealplanctl or remind).
+
+// One cell that changed, as sent to the page.
+type cellEvent struct {
+	Day      string         `json:"day"`
+	Duty     string         `json:"duty"`
+	Assignee moira.Username `json:"assignee"`
+	Name     string         `json:"name"` // display name of Assignee
+}
+
+type broker struct {
+	sync.Mutex
+	clients map[chan string]bool
+	last    map[string]map[string]moira.Username // the assignments as of the last check
+	version string                               // and their VersionID
+	closed  bool
+}
+
+var events = &broker{clients: map[chan string]bool{}}
+
+// How often to look for changes made by other programs.
+const eventsPollInterval = 5 * time.Second
+
+// Each client gets this many events queued up for it; one which falls further behind than that is
+// disconnected, and reloads the page when it reconnects.
+const eventsBuffer = 64
+
+// Start listening for events, which will be changes since the version returned. The channel is
+// closed if the client falls behind or the server is shutting down.
+func (b *broker) subscribe() (chan string, string) {
+	b.Lock()
+	defer b.Unlock()
+	ch := make(chan string, eventsBuffer)
+	if b.closed {
+		close(ch)
+		return ch, b.version
+	}
+	b.clients[ch] = true
+	return ch, b.version
+}
+
+func (b *broker) unsubscribe(ch chan string) {
+	b.Lock()
+	defer b.Unlock()
+	if b.clients[ch] {
+		delete(b.clients, ch)
+		close(ch)
+	}
+}
+
+// Disconnect everybody, so the server can shut down.
+func (b *broker) shutdown() {
+	b.Lock()
+	defer b.Unlock()
+	b.closed = true
+	for ch := range b.clients {
+		delete(b.clients, ch)
+		close(ch)
+	}
+}
+
+// Compare the data with how it was last time, and send every cell which changed to everybody. (The
+// first time, there's nothing to compare with, so nothing gets sent.)
+func (b *broker) check(data *Data, profiles Profiles) {
+	b.Lock()
+	defer b.Unlock()
+	if data.VersionID == b.version {
+		return
+	}
+	changes := []cellEvent{}
+	if b.last != nil {
+		for day, dayAssignments := range data.Assignments {
+			for duty, assignee := range dayAssignments {
+				if b.last[day][duty] != assignee {
+					changes = append(changes, cellEvent{Day: day, Duty: duty, Assignee: assignee, Name: profiles.DisplayName(assignee)})
+				}
+			}
+		}
+		for day, dayAssignments := range b.last {
+			for duty, assignee := range dayAssignments {
+				if _, ok := data.Assignments[day][duty]; !ok && assignee != "" {
+					changes = append(changes, cellEvent{Day: day, Duty: duty})
+				}
+			}
+		}
+	}
+
+	b.last = map[string]map[string]moira.Username{}
+	for day, dayAssignments := range data.Assignments {
+		b.last[day] = map[string]moira.Username{}
+		for duty, assignee := range dayAssignments {
+			b.last[day][duty] = assignee
+		}
+	}
+	b.version = data.VersionID
+
+	for _, change := range changes {
+		jsonBytes, err := json.Marshal(change)
+		if err != nil {
+			continue
+		}
+		event := fmt.Sprintf("id: %s\nevent: cell\ndata: %s\n\n", data.VersionID, jsonBytes)
+		for ch := range b.clients {
+			select {
+			case ch <- event:
+			default:
+				// Too far behind; it'll reconnect and catch up
+				delete(b.clients, ch)
+				close(ch)
+			}
+		}
+	}
+}
+
+// Read the data file and send any changes (see check). The caller has to hold dataLock.
+func publishChanges() error {
+	data, err := ReadData(config.DataFile)
+	if err != nil {
+		return err
+	}
+	profiles, err := ReadProfiles(config.ProfilesFile)
+	if err != nil {
+		return err
+	}
+	events.check(data, profiles)
+	return nil
+}
+
+// Keep checking for changes made by other programs (see check), until the context is done.
+func pollForEvents(ctx context.Context) {
+	ticker := time.NewTicker(eventsPollInterval)
+	defer ticker.Stop()
+	for {
+		dataLock.Lock()
+		err := publishChanges()
+		dataLock.Unlock()
+		if err != nil {
+			slog.Error("couldn't check for changes to send out", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// This handler streams changes to the assignments to the signup page (see broker). The page says
+// which version of the data it's showing (?since=, or Last-Event-ID when reconnecting); if anything
+// has changed since then, it's told to reload instead, since those changes won't be sent again.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	// Catch up first, in case something else changed the data just now
+	dataLock.Lock()
+	err := publishChanges()
+	dataLock.Unlock()
+	if err != nil {
+		handleErr(w, r, err)
+		return
+	}
+	ch, version := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// (reconnect after a few seconds if disconnected)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	since := r.Header.Get("Last-Event-ID")
+	if since == "" {
+		since = r.FormValue("since")
+	}
+	if since != "" && since != version {
+		fmt.Fprint(w, "event: stale\ndata: reload\n\n")
+	}
+	controller := http.NewResponseController(w)
+	if err := controller.Flush(); err != nil {
+		logFor(r).Error("can't stream events", "err", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			fmt.Fprint(w, event)
+		case <-keepAlive.C:
+			// A comment, so proxies don't give up on the connection
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+type loggerKey struct{}
+
+// Set up structured logging at the configured level, to the configured log file or else stderr.
+// Everything which still uses the standard log package (e.g. moira) ends up here too.
+func setupLogging(level, logFile string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return err
+	}
+	out := os.Stderr
+	if logFile != "" {
+		var err error
+		if out, err = os.OpenFile(logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err != nil {
+			return err
+		}
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: l})))
+	return nil
+}
+
+func newRequestID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Give the request an ID (also sent back in the X-Request-Id header) and a logger which includes it,
+// for logFor to find.
+func withRequestLogger(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := newRequestID()
+	w.Header().Set("X-Request-Id", id)
+	logger := slog.Default().With("request_id", id, "method", r.Method, "path", r.URL.Path)
+	return r.WithContext(context.WithValue(r.Context(), loggerKey{}, logger))
+}
+
+// Add the authenticated user to the request's logger.
+func withLoggedUser(r *http.Request) *http.Request {
+	logger := logFor(r).With("user", getAuthedUsername(r))
+	return r.WithContext(context.WithValue(r.Context(), loggerKey{}, logger))
+}
+
+// The logger to use while handling a request, which labels everything with the request ID and user.
+func logFor(r *http.Request) *slog.Logger {
+	if logger, ok := r.Context().Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -8,13 +8,20 @@ import (
 	"crypto/x509"
 	"encoding/asn1"
 	"errors"
+	"fmt"
 	"golang.org/x/crypto/acme"	
 	"golang.org/x/crypto/acme/autocert"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 	"github.com/pikans/mealplan/moira"
+	. "github.com/pikans/mealplan"
 )
 
 var deprecatedRSAIncEmailAddressForUseInSignatures = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
@@ -40,7 +47,13 @@ func getMITCertEmailAddressFullName(chains [][]*x509.Certificate) (moira.Email,
 	return "", "", errors.New("no MIT certificate email address found")
 }
 
-func run(handler http.Handler, unauthHandler http.Handler, register, listenhttp, listenhttps, authenticate, authorize, state string) {
+// How long to wait for in-progress requests (which might be in the middle of writing the data
+// file) to finish when shutting down.
+const shutdownTimeout = 30 * time.Second
+
+// Serve until the context is canceled (on SIGINT or SIGTERM) or one of the listeners fails, then
+// shut both servers down gracefully, letting in-progress requests finish.
+func run(ctx context.Context, handler http.Handler, unauthHandler http.Handler, register, listenhttp, listenhttps, authenticate, authorize, state string) error {
 	letsEncryptManager := &autocert.Manager{
 		Cache:      autocert.DirCache(state),
 		Prompt:     autocert.AcceptTOS,
@@ -50,11 +63,11 @@ func run(handler http.Handler, unauthHandler http.Handler, register, listenhttp,
 
 	clientCAsPEM, err := ioutil.ReadFile(authenticate)
 	if err != nil {
-		log.Fatalf("error reading client CAs file: %s", err)
+		return fmt.Errorf("error reading client CAs file: %s", err)
 	}
 	clientCAs := x509.NewCertPool()
 	if !clientCAs.AppendCertsFromPEM(clientCAsPEM) {
-		log.Fatalf("failed to parse client CA certificate")
+		return errors.New("failed to parse client CA certificate")
 	}
 
 	doAuthorize := func(req *http.Request) error {
@@ -71,8 +84,12 @@ func run(handler http.Handler, unauthHandler http.Handler, register, listenhttp,
 		return nil
 	}
 
-	go func() { log.Fatal(http.ListenAndServe(listenhttp, letsEncryptManager.HTTPHandler(nil))) }()
-	srv := &http.Server{
+	ops := getOpsHandler()
+	httpSrv := &http.Server{
+		Addr:    listenhttp,
+		Handler: letsEncryptManager.HTTPHandler(nil),
+	}
+	httpsSrv := &http.Server{
 		Addr: listenhttps,
 		TLSConfig: &tls.Config{
 			GetCertificate: letsEncryptManager.GetCertificate,
@@ -84,29 +101,127 @@ func run(handler http.Handler, unauthHandler http.Handler, register, listenhttp,
 			},
 		},
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			req = withRequestLogger(w, req)
+			// Monitoring doesn't need a certificate
+			if _, pattern := ops.Handler(req); pattern != "" {
+				ops.ServeHTTP(w, req)
+				return
+			}
 			if err := doAuthorize(req); err == nil {
-				handler.ServeHTTP(w, req)
+				handler.ServeHTTP(w, withLoggedUser(req))
 			} else {
+				logFor(req).Debug("not authorized", "err", err)
 				unauthHandler.ServeHTTP(w, req)
 			}
 		}),
 	}
 
-	log.Fatal(srv.ListenAndServeTLS("", ""))
+	// Streams of events (see eventsHandler) never finish by themselves
+	httpSrv.RegisterOnShutdown(events.shutdown)
+	httpsSrv.RegisterOnShutdown(events.shutdown)
+
+	errs := make(chan error, 2)
+	go func() { errs <- httpSrv.ListenAndServe() }()
+	go func() { errs <- httpsSrv.ListenAndServeTLS("", "") }()
+	slog.Info("listening", "http", listenhttp, "https", listenhttps)
+
+	// Either listener failing takes the other one down with it
+	var runErr error
+	select {
+	case <-ctx.Done():
+		slog.Info("shutting down")
+	case runErr = <-errs:
+		slog.Error("server failed, shutting down", "err", runErr)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, srv := range []*http.Server{httpSrv, httpsSrv} {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("error shutting down", "addr", srv.Addr, "err", err)
+		}
+	}
+	return runErr
 }
 
-var register = flag.String("register", "", "(optional) email address for letsencrypt registration")
-var listenhttp = flag.String("listenhttp", ":http", "host:port to listen for HTTP on")
-var listenhttps = flag.String("listenhttps", ":https", "host:port to listen for HTTPS on")
-var authenticate = flag.String("authenticate", "", "path to a file containing PEM-format x509 certificates for the CAs trusted to authenticate clients")
-var authorize = flag.String("authorize", "", "name of moira list whose members are authorized. The list MUST be marked as a NFS group (blanche listname -N)")
-var state = flag.String("state", "", "path at which the letsencrypt server state will be recorded")
+var configFile = flag.String("config", ConfigFile, "path to the JSON configuration file (see mealplan-config.example.json)")
+
+// The configuration, read from the config file at startup
+var config *Config
+
+// Where outgoing email goes to be sent in the background
+var outbox *Outbox
+
+// Check the data file for problems (see CheckData) before serving it: refuse to start if there are
+// serious ones, and log the rest.
+func checkDataFile() error {
+	data, err := ReadData(config.DataFile)
+	if err != nil {
+		return fmt.Errorf("can't read the data file: %v", err)
+	}
+	members, err := moira.GetMoiraNFSGroupMembers(config.Authorize)
+	if err != nil {
+		slog.Warn("not checking who's assigned, since the list couldn't be looked up", "list", config.Authorize, "err", err)
+		members = nil
+	}
+	problems := CheckData(data, members, config.Now().Format(DateFormat))
+	for _, p := range problems {
+		slog.Warn("problem with the data file", "problem", p.String())
+	}
+	if AnySerious(problems) {
+		return fmt.Errorf("not starting, since %s has problems (see above); \"mealplanctl fsck -fix\" can fix most of them", config.DataFile)
+	}
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags]\n       %s [flags] install-service [-init systemd|rc.d] [-user name]\n", os.Args[0], os.Args[0])
+	flag.PrintDefaults()
+}
 
 func main() {
+	flag.Usage = usage
 	flag.Parse()
-	if *authenticate == "" || *authorize == "" || *state == "" {
+	var err error
+	if config, err = ReadConfig(*configFile); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupLogging(config.LogLevel, config.LogFile); err != nil {
+		log.Fatalf("error setting up logging: %s", err)
+	}
+
+	if flag.NArg() != 0 {
+		if flag.Arg(0) != "install-service" {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := installService(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if config.Authenticate == "" || config.Authorize == "" || config.State == "" {
 		flag.Usage()
-		log.Fatal("please specify the required arguments")
+		log.Fatal("please set Authenticate, Authorize and State in the config file")
+	}
+	moira.Dir = timedDirectory{config.Directory.Open()}
+	if err := checkDataFile(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadTemplates(); err != nil {
+		log.Fatalf("error loading templates: %s", err)
+	}
+	if outbox, err = config.Outbox(); err != nil {
+		log.Fatalf("error opening outbox: %s", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go outbox.Run(ctx, time.Minute)
+	go pollForEvents(ctx)
+	if err := run(ctx, getHandler(), getUnauthHandler(), config.Register, config.ListenHTTP, config.ListenHTTPS, config.Authenticate, config.Authorize, config.State); err != nil {
+		slog.Error("exiting", "err", err)
+		os.Exit(1)
 	}
-	run(getHandler(), getUnauthHandler(), *register, *listenhttp, *listenhttps, *authenticate, *authorize, *state)
 }
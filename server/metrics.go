@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/pikans/mealplan"
+	"github.com/pikans/mealplan/moira"
+)
+
+// The server's metrics, served at /metrics in the Prometheus text format. There are few enough of
+// them that it's simpler to keep them by hand than to pull in the Prometheus client library.
+var metrics = struct {
+	sync.Mutex
+	requests        map[string]map[int]uint64 // by handler, then status code
+	requestDuration map[string]*histogram     // by handler
+	claims          uint64
+	abandons        uint64
+	ldapDuration    histogram
+}{
+	requests:        map[string]map[int]uint64{},
+	requestDuration: map[string]*histogram{},
+	ldapDuration:    newHistogram(),
+}
+
+// Bucket boundaries (in seconds) used for all the latency histograms.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64 // count of observations <= each of latencyBuckets
+	sum    float64
+	count  uint64
+}
+
+func newHistogram() histogram {
+	return histogram{counts: make([]uint64, len(latencyBuckets))}
+}
+
+func (h *histogram) observe(seconds float64) {
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, bound := range latencyBuckets {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%g\"} %d\n", name, labels, sep, bound, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+// Remembers the status code a handler responded with, so it can be counted.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Wrap a handler so its requests get counted and timed under the given name.
+func instrument(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler(recorder, r)
+		elapsed := time.Since(start).Seconds()
+
+		metrics.Lock()
+		defer metrics.Unlock()
+		if metrics.requests[name] == nil {
+			metrics.requests[name] = map[int]uint64{}
+			h := newHistogram()
+			metrics.requestDuration[name] = &h
+		}
+		metrics.requests[name][recorder.status]++
+		metrics.requestDuration[name].observe(elapsed)
+	}
+}
+
+// A moira directory which times how long each lookup takes.
+type timedDirectory struct {
+	moira.Directory
+}
+
+func (d timedDirectory) Members(nfsgroup string) ([]moira.Username, error) {
+	start := time.Now()
+	members, err := d.Directory.Members(nfsgroup)
+	elapsed := time.Since(start).Seconds()
+	metrics.Lock()
+	metrics.ldapDuration.observe(elapsed)
+	metrics.Unlock()
+	return members, err
+}
+
+// The number of duties which are important enough that dinner may be canceled without them (the
+// ImportantDuties of the reminder groups), but which nobody has claimed, in the next week.
+func unfilledRequiredShifts(data *Data) int {
+	required := config.RequiredDuties()
+	unfilled := 0
+	today := config.Now()
+	for i := 0; i < 7; i++ {
+		day := today.AddDate(0, 0, i).Format(DateFormat)
+		for _, duty := range data.Duties {
+			if required[duty] && data.Assignments[day][duty] == "" {
+				unfilled++
+			}
+		}
+	}
+	return unfilled
+}
+
+// Serves /metrics.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	dataLock.Lock()
+	currentData, err := ReadData(config.DataFile)
+	dataLock.Unlock()
+	if err != nil {
+		handleErr(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	handlers := []string{}
+	for name := range metrics.requests {
+		handlers = append(handlers, name)
+	}
+	sort.Strings(handlers)
+
+	fmt.Fprintln(w, "# HELP mealplan_http_requests_total HTTP requests handled, by handler and status code.")
+	fmt.Fprintln(w, "# TYPE mealplan_http_requests_total counter")
+	for _, name := range handlers {
+		codes := []int{}
+		for code := range metrics.requests[name] {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Fprintf(w, "mealplan_http_requests_total{handler=%q,code=\"%d\"} %d\n", name, code, metrics.requests[name][code])
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP mealplan_http_request_duration_seconds Time taken to handle HTTP requests, by handler.")
+	fmt.Fprintln(w, "# TYPE mealplan_http_request_duration_seconds histogram")
+	for _, name := range handlers {
+		metrics.requestDuration[name].write(w, "mealplan_http_request_duration_seconds", fmt.Sprintf("handler=%q", name))
+	}
+
+	fmt.Fprintln(w, "# HELP mealplan_claims_total Duties claimed.")
+	fmt.Fprintln(w, "# TYPE mealplan_claims_total counter")
+	fmt.Fprintf(w, "mealplan_claims_total %d\n", metrics.claims)
+	fmt.Fprintln(w, "# HELP mealplan_abandons_total Duties abandoned.")
+	fmt.Fprintln(w, "# TYPE mealplan_abandons_total counter")
+	fmt.Fprintf(w, "mealplan_abandons_total %d\n", metrics.abandons)
+
+	fmt.Fprintln(w, "# HELP mealplan_ldap_lookup_duration_seconds Time taken to look up moira list members.")
+	fmt.Fprintln(w, "# TYPE mealplan_ldap_lookup_duration_seconds histogram")
+	metrics.ldapDuration.write(w, "mealplan_ldap_lookup_duration_seconds", "")
+
+	fmt.Fprintln(w, "# HELP mealplan_unfilled_required_shifts Important duties nobody has claimed in the next 7 days.")
+	fmt.Fprintln(w, "# TYPE mealplan_unfilled_required_shifts gauge")
+	fmt.Fprintf(w, "mealplan_unfilled_required_shifts %d\n", unfilledRequiredShifts(currentData))
+}
+
+// Serves /healthz: if we can answer at all, the process is up.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// How long /readyz goes by the last check, so that anybody hitting it can't make us hammer LDAP.
+const readyzCacheTime = 30 * time.Second
+
+var readiness struct {
+	sync.Mutex
+	checked time.Time
+	ready   bool
+}
+
+// Checks that we can actually do our job, i.e. read the data file and look up who's authorized.
+// What's wrong (if anything) is logged, rather than shown to whoever asked.
+func checkReady() bool {
+	problems := []string{}
+	dataLock.Lock()
+	_, err := ReadData(config.DataFile)
+	dataLock.Unlock()
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("can't read data file: %v", err))
+	}
+	if _, err := moira.GetMoiraNFSGroupMembers(config.Authorize); err != nil {
+		problems = append(problems, fmt.Sprintf("can't look up moira list %v: %v", config.Authorize, err))
+	}
+	if len(problems) != 0 {
+		slog.Warn("not ready", "problems", strings.Join(problems, "; "))
+		return false
+	}
+	return true
+}
+
+// Serves /readyz: whether checkReady passed (as of at most readyzCacheTime ago).
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	readiness.Lock()
+	if time.Since(readiness.checked) > readyzCacheTime {
+		readiness.ready = checkReady()
+		readiness.checked = time.Now()
+	}
+	ready := readiness.ready
+	readiness.Unlock()
+	if !ready {
+		http.Error(w, "not ready (see the server's log)", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// Only lets in requests from this machine (e.g. Prometheus, or an SSH tunnel), for the metrics,
+// which say more about the house than anybody outside needs to know.
+func localOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// The monitoring endpoints, which are served without a certificate (/metrics only on this machine).
+func getOpsHandler() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+	mux.HandleFunc("/metrics", localOnly(metricsHandler))
+	return mux
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+var systemdUnit = template.Must(template.New("systemd").Parse(`# Install as /etc/systemd/system/mealplan.service, then:
+#   systemctl daemon-reload && systemctl enable --now mealplan
+[Unit]
+Description=pika mealplan server
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+User={{.User}}
+WorkingDirectory={{.Dir}}
+ExecStart={{.Binary}} -config {{.Config}}
+Restart=on-failure
+# Let the server listen on ports 80 and 443 without running as root
+AmbientCapabilities=CAP_NET_BIND_SERVICE
+# The server finishes in-progress requests when it gets SIGTERM
+KillSignal=SIGTERM
+TimeoutStopSec=60
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+var rcdScript = template.Must(template.New("rc.d").Parse(`#!/bin/ksh
+#
+# Install as /etc/rc.d/mealplan (mode 555), then:
+#   rcctl enable mealplan && rcctl start mealplan
+# rc.d throws away the server's output, so set LogFile in the config file to keep the logs.
+
+daemon="{{.Binary}}"
+daemon_flags="-config {{.Config}}"
+daemon_user="{{.User}}"
+daemon_execdir="{{.Dir}}"
+
+. /etc/rc.d/rc.subr
+
+rc_bg=YES
+rc_reload=NO
+
+rc_cmd $1
+`))
+
+// Implements "server install-service": prints a systemd unit or OpenBSD rc.d script which runs this
+// binary, with this config file, from the current directory.
+func installService(args []string) error {
+	flags := flag.NewFlagSet("install-service", flag.ExitOnError)
+	init := flags.String("init", "systemd", "which init system to generate a service for: systemd or rc.d")
+	user := flags.String("user", "mealplan", "user to run the server as")
+	flags.Parse(args)
+
+	var t *template.Template
+	switch *init {
+	case "systemd":
+		t = systemdUnit
+	case "rc.d":
+		t = rcdScript
+	default:
+		return fmt.Errorf("unknown init system %q (want systemd or rc.d)", *init)
+	}
+
+	binary, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	configPath, err := filepath.Abs(*configFile)
+	if err != nil {
+		return err
+	}
+	return t.Execute(os.Stdout, struct {
+		Binary, Config, Dir, User string
+	}{binary, configPath, dir, *user})
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
-	"html/template"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
-	"net/smtp"
+	"net/mail"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -21,39 +25,118 @@ var dataLock sync.Mutex
 
 // The data type which will be passed to the HTML template (signup.html).
 type DisplayData struct {
-	Duties      []string
+	Duties      []string            // on the signup sheet
+	WeekDuties  map[string][]string // the duties shown for each week, by its first day (see DutiesForWeek)
 	Authorized  bool
 	Username    moira.Username
 	DayNames    map[string]string
 	Weeks       [][]string
 	Assignments map[string]map[string]moira.Username
+	Names       map[moira.Username]string // display name for each assignee
 	EndDate     string
 	VersionID   string
+	Conflicts   map[string]map[string]*CellConflict // (admin) by day and duty, changes which couldn't be saved
+	Notes       []string                            // (admin) what happened when saving
+	ViewingAs   moira.Username                      // the admin looking at the page as Username, if any (see viewAs)
+	Window      Window                              // which weeks are shown
+	Today       string                              // days before this can't be claimed or abandoned any more
 }
 
-func makeWeeksAndDayNames(endDate string) ([][]string, map[string]string) {
-	weeks := [][]string{}
-	dayNames := map[string]string{}
+// A change to a cell on the admin page which wasn't saved, because somebody else changed the cell
+// after the page was loaded.
+type CellConflict struct {
+	Was    moira.Username // what the admin's page showed
+	Yours  moira.Username // what the admin changed it to
+	Theirs moira.Username // what somebody else changed it to in the meantime
+}
+
+// The duties to show for each of the weeks (see DutiesForWeek), by the week's first day.
+func weekDuties(data *Data, weeks [][]string) map[string][]string {
+	duties := map[string][]string{}
+	for _, days := range weeks {
+		duties[days[0]] = data.DutiesForWeek(days[0])
+	}
+	return duties
+}
+
+// Look up the display name of everybody who has been assigned anything, for the templates to show
+// instead of raw usernames.
+func displayNames(profiles Profiles, data *Data) map[moira.Username]string {
+	names := map[moira.Username]string{}
+	for _, dayAssignments := range data.Assignments {
+		for _, assignee := range dayAssignments {
+			names[assignee] = profiles.DisplayName(assignee)
+		}
+	}
+	return names
+}
 
-	today := time.Now()
-	todayOffset := today.Weekday() - time.Monday
-	if todayOffset < 0 {
-		todayOffset += 7
+// Which weeks a page shows. By default that's this week through the end date (but no more than
+// defaultWeeks of them, so the page doesn't grow without limit); ?from=YYYY-MM-DD&weeks=N shows N
+// weeks starting with the one with that day in it instead, to look back at old weeks or link to a
+// particular one.
+type Window struct {
+	From    string // the Monday of the first week
+	Weeks   int
+	Custom  bool   // whether it came from ?from= or ?weeks=, rather than being the default
+	Earlier string // where the window of the same size before this one starts
+	Later   string // and the one after
+}
+
+const (
+	defaultWeeks = 16
+	maxWeeks     = 52
+)
+
+func windowFor(r *http.Request, endDate string) (Window, error) {
+	from := MondayOf(config.Now())
+	window := Window{}
+	if day := r.FormValue("from"); day != "" {
+		date, err := config.ParseDay(day)
+		if err != nil {
+			return window, fmt.Errorf("Invalid date %v, please provide a date in YYYY-MM-DD format", day)
+		}
+		from, window.Custom = MondayOf(date), true
 	}
-	actualStart := today.AddDate(0, 0, -int(todayOffset))
+	if weeks := r.FormValue("weeks"); weeks != "" {
+		n, err := strconv.Atoi(weeks)
+		if err != nil || n < 1 || n > maxWeeks {
+			return window, fmt.Errorf("weeks should be a number from 1 to %d", maxWeeks)
+		}
+		window.Weeks, window.Custom = n, true
+	} else {
+		// Through the end date
+		end, _ := config.ParseDay(endDate)
+		window.Weeks = WeeksThrough(from, end, defaultWeeks)
+	}
+	window.From = from.Format(DateFormat)
+	window.Earlier = from.AddDate(0, 0, -7*window.Weeks).Format(DateFormat)
+	window.Later = from.AddDate(0, 0, 7*window.Weeks).Format(DateFormat)
+	return window, nil
+}
 
-	end, _ := time.Parse(DateFormat, endDate)
-	if end.Before(today) {
-		end = today
+// Where to go back to after a change made on a page showing the window in the form (see Window).
+func windowURL(path string, r *http.Request) string {
+	query := url.Values{}
+	for _, key := range []string{"from", "weeks"} {
+		if value := r.FormValue(key); value != "" {
+			query.Set(key, value)
+		}
 	}
-	endOffset := time.Sunday - end.Weekday()
-	if endOffset < 0 {
-		endOffset += 7
+	if len(query) == 0 {
+		return path
 	}
-	actualEnd := end.AddDate(0, 0, int(endOffset))
+	return path + "?" + query.Encode()
+}
+
+func makeWeeksAndDayNames(window Window) ([][]string, map[string]string) {
+	weeks := [][]string{}
+	dayNames := map[string]string{}
 
-	for day := actualStart; day.Year() < actualEnd.Year() || (day.Year() == actualEnd.Year() && day.YearDay() <= actualEnd.YearDay()); day = day.AddDate(0, 0, 1) {
-		if day.Weekday() == time.Monday {
+	from, _ := config.ParseDay(window.From)
+	for i := 0; i < 7*window.Weeks; i++ {
+		day := from.AddDate(0, 0, i)
+		if i%7 == 0 {
 			weeks = append(weeks, []string{})
 		}
 		dayString := day.Format(DateFormat)
@@ -63,10 +146,9 @@ func makeWeeksAndDayNames(endDate string) ([][]string, map[string]string) {
 	return weeks, dayNames
 }
 
-
-func handleErr(w http.ResponseWriter, err error) {
+func handleErr(w http.ResponseWriter, r *http.Request, err error) {
 	http.Error(w, err.Error(), http.StatusInternalServerError)
-	log.Printf("%s\n", err)
+	logFor(r).Error("request failed", "err", err)
 }
 
 // This handler runs for unauthorized users (no certs / not on pika-food).
@@ -74,30 +156,39 @@ func handleErr(w http.ResponseWriter, err error) {
 // buttons or checkboxes for the users to make any changes. (This is taken care of in signup.html,
 // which checks .Authorized on the data to check whether the user is authorized or not.)
 func unauthHandler(w http.ResponseWriter, r *http.Request) {
-	t, err := template.ParseFiles("signup.html")
+	dataLock.Lock()
+	defer dataLock.Unlock()
+	currentData, err := ReadData(config.DataFile)
 	if err != nil {
-		handleErr(w, err)
+		handleErr(w, r, err)
 		return
 	}
-	dataLock.Lock()
-	defer dataLock.Unlock()
-	currentData, err := ReadData(DataFile)
+	profiles, err := ReadProfiles(config.ProfilesFile)
 	if err != nil {
-		handleErr(w, err)
+		handleErr(w, r, err)
 		return
 	}
-	weeks, dayNames := makeWeeksAndDayNames(currentData.EndDate)
+	window, err := windowFor(r, currentData.EndDate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	weeks, dayNames := makeWeeksAndDayNames(window)
 	d := DisplayData{
 		Duties:      currentData.Duties,
+		WeekDuties:  weekDuties(currentData, weeks),
 		Authorized:  false,
 		Username:    "",
 		DayNames:    dayNames,
 		Weeks:       weeks,
 		Assignments: currentData.Assignments,
+		Names:       displayNames(profiles, currentData),
 		EndDate:     currentData.EndDate,
 		VersionID:   currentData.VersionID,
+		Window:      window,
+		Today:       config.Now().Format(DateFormat),
 	}
-	err = t.Execute(w, d)
+	err = renderPage(w, "signup.html", d)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -107,24 +198,18 @@ func unauthHandler(w http.ResponseWriter, r *http.Request) {
 // This handler displays the main signup page for authorized users (certs & on pika-food).
 // It displays buttons and checkboxes to enable the user to claim duties.
 func signupHandler(w http.ResponseWriter, r *http.Request) {
-	t, err := template.ParseFiles("signup.html")
-	if err != nil {
-		handleErr(w, err)
-		return
-	}
 	dataLock.Lock()
 	defer dataLock.Unlock()
-	currentData, err := ReadData(DataFile)
+	currentData, err := ReadData(config.DataFile)
 	if err != nil {
-		handleErr(w, err)
+		handleErr(w, r, err)
 		return
 	}
-	username := getAuthedUsername(r)
-	if username == "" {
-		http.Error(w, "No username", http.StatusUnauthorized)
+	username, admin, ok := viewAs(w, r)
+	if !ok {
 		return
 	}
-	log.Printf("displaying for user %v", username)
+	logFor(r).Debug("displaying signup page")
 	for _, duty := range currentData.Duties {
 		// If duties contain slashes, the logic in claimHandler will break, because the button IDs use
 		// slashes as separators (see signup.html).
@@ -132,18 +217,94 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 			panic("duties can't contain slashes")
 		}
 	}
-	weeks, dayNames := makeWeeksAndDayNames(currentData.EndDate)
+	profiles, err := ReadProfiles(config.ProfilesFile)
+	if err != nil {
+		handleErr(w, r, err)
+		return
+	}
+	window, err := windowFor(r, currentData.EndDate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	weeks, dayNames := makeWeeksAndDayNames(window)
 	d := DisplayData{
 		Duties:      currentData.Duties,
+		WeekDuties:  weekDuties(currentData, weeks),
 		Authorized:  true,
 		Username:    username,
 		DayNames:    dayNames,
 		Weeks:       weeks,
 		Assignments: currentData.Assignments,
+		Names:       displayNames(profiles, currentData),
 		EndDate:     currentData.EndDate,
 		VersionID:   currentData.VersionID,
+		ViewingAs:   admin,
+		Window:      window,
+		Today:       config.Now().Format(DateFormat),
 	}
-	err = t.Execute(w, d)
+	d.Names[username] = profiles.DisplayName(username)
+	err = renderPage(w, "signup.html", d)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// The data type which will be passed to the printable sheet template (print.html).
+type PrintData struct {
+	Grid               DisplayData // just the one week
+	Week               string      // its Monday
+	PrevWeek, NextWeek string
+}
+
+// This handler displays one week (the one with ?week=YYYY-MM-DD in it, or this week) as a sheet to
+// print out and put on the fridge: everybody's display names, when each duty is (DutyTimes in the
+// config file) and which are closed, with no buttons.
+func printHandler(w http.ResponseWriter, r *http.Request) {
+	day := config.Now()
+	if week := r.FormValue("week"); week != "" {
+		var err error
+		if day, err = config.ParseDay(week); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid date %v, please provide a date in YYYY-MM-DD format", week), http.StatusBadRequest)
+			return
+		}
+	}
+	monday := MondayOf(day)
+
+	dataLock.Lock()
+	defer dataLock.Unlock()
+	currentData, err := ReadData(config.DataFile)
+	if err != nil {
+		handleErr(w, r, err)
+		return
+	}
+	profiles, err := ReadProfiles(config.ProfilesFile)
+	if err != nil {
+		handleErr(w, r, err)
+		return
+	}
+	days := []string{}
+	dayNames := map[string]string{}
+	for i := 0; i < 7; i++ {
+		date := monday.AddDate(0, 0, i)
+		days = append(days, date.Format(DateFormat))
+		dayNames[date.Format(DateFormat)] = date.Format("Monday (1/2)")
+	}
+	d := PrintData{
+		Grid: DisplayData{
+			Duties:      currentData.Duties,
+			WeekDuties:  weekDuties(currentData, [][]string{days}),
+			DayNames:    dayNames,
+			Weeks:       [][]string{days},
+			Assignments: currentData.Assignments,
+			Names:       displayNames(profiles, currentData),
+		},
+		Week:     days[0],
+		PrevWeek: monday.AddDate(0, 0, -7).Format(DateFormat),
+		NextWeek: monday.AddDate(0, 0, 7).Format(DateFormat),
+	}
+	err = renderPage(w, "print.html", d)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -155,26 +316,120 @@ func getAuthedUsername(r *http.Request) moira.Username {
 	return moira.UsernameFromEmail(email)
 }
 
+// Works out whose page to show. Normally that's the logged-in user's, but an admin can add
+// ?as=<username> to the signup page or /me to see exactly what that person sees, to get to the
+// bottom of what they're reporting. Then admin is the admin's own username, and the page should be
+// read-only; every look is written to the audit log. Returns ok=false if the request has already
+// been answered with an error.
+func viewAs(w http.ResponseWriter, r *http.Request) (username, admin moira.Username, ok bool) {
+	username = getAuthedUsername(r)
+	if username == "" {
+		http.Error(w, "No username", http.StatusUnauthorized)
+		return "", "", false
+	}
+	as := moira.Username(strings.TrimSpace(r.URL.Query().Get("as")))
+	if as == "" || as == username {
+		return username, "", true
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Can't change anything while viewing the page as somebody else", http.StatusForbidden)
+		return "", "", false
+	}
+	if !adminAuth(w, r) {
+		return "", "", false
+	}
+	logFor(r).Info("viewing as", "as", as, "page", r.URL.Path)
+	audit(r, "view-as", fmt.Sprintf("%s as %s", r.URL.Path, as))
+	return as, username, true
+}
+
+// Change the data (see Transact). The data file is also locked against other programs, but
+// dataLock keeps this server's own readers from seeing anything in between. Then the change goes
+// out to everybody watching for it.
 func transact(f func(*Data) error) error {
 	dataLock.Lock()
 	defer dataLock.Unlock()
-
-	currentData, err := ReadData(DataFile)
-	if err != nil {
+	if err := Transact(config.DataFile, f); err != nil {
 		return err
 	}
+	// Show the change on everybody's signup page (see events.go)
+	if err := publishChanges(); err != nil {
+		slog.Error("couldn't send out the change", "err", err)
+	}
+	return nil
+}
 
-	if err := f(currentData); err != nil {
-		return err
+// The data type which will be passed to the confirmation template (confirm.html).
+type ConfirmData struct {
+	Action      string // "claim" or "abandon"
+	Duty        string
+	Day         string
+	Description string
+	Assignee    string // display name of whoever has it now, if anybody
+	Mine        bool
+}
+
+// Tell the house chat that a duty was abandoned.
+func postAbandon(logger *slog.Logger, name, duty, day string) {
+	if err := config.Webhook.Post(AbandonWebhookEvent(config, name, duty, day)); err != nil {
+		logger.Error("couldn't post abandon to the webhook", "err", err)
 	}
+}
 
-	return WriteData(DataFile, currentData)
+// This handler displays a page with a single claim or abandon button for the duty given in the URL
+// (?action=claim&duty=...&day=...), which the links in emails point to. The button submits to
+// claimHandler just like the ones on the main page.
+func confirmHandler(w http.ResponseWriter, r *http.Request) {
+	username := getAuthedUsername(r)
+	action, duty, day := r.FormValue("action"), r.FormValue("duty"), r.FormValue("day")
+	if action != "claim" && action != "abandon" {
+		http.Error(w, fmt.Sprintf("Unknown action %q", action), http.StatusBadRequest)
+		return
+	}
+	if _, err := time.Parse(DateFormat, day); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid date %v", day), http.StatusBadRequest)
+		return
+	}
+
+	dataLock.Lock()
+	defer dataLock.Unlock()
+	currentData, err := ReadData(config.DataFile)
+	if err != nil {
+		handleErr(w, r, err)
+		return
+	}
+	profiles, err := ReadProfiles(config.ProfilesFile)
+	if err != nil {
+		handleErr(w, r, err)
+		return
+	}
+	d := ConfirmData{
+		Action:      action,
+		Duty:        duty,
+		Day:         day,
+		Description: config.DutyDescriptions[duty],
+	}
+	if assignee := currentData.Assignments[day][duty]; assignee != "" {
+		d.Assignee = profiles.DisplayName(assignee)
+		d.Mine = assignee == username
+	}
+	err = renderPage(w, "confirm.html", d)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 }
 
 // This handler runs when users submit the form (by clicking Save or a duty-claiming button).
 // It updates the on-disk data correspondingly, and then sends users back to the main page.
 func claimHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
+		// Links in emails come here; make the user confirm, so that just following a link never
+		// changes anything
+		if r.FormValue("duty") != "" {
+			confirmHandler(w, r)
+			return
+		}
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
@@ -183,6 +438,7 @@ func claimHandler(w http.ResponseWriter, r *http.Request) {
 	username := getAuthedUsername(r)
 	if username == "" {
 		http.Error(w, "No username", http.StatusUnauthorized)
+		return
 	}
 
 	// Find whether a duty was claimed, and if so, which one
@@ -191,59 +447,66 @@ func claimHandler(w http.ResponseWriter, r *http.Request) {
 		if len(splitKey) == 3 && splitKey[0] == "claim" {
 			duty := splitKey[1]
 			day := splitKey[2]
-			err := transact(func(currentData *Data) error {
-				dayAssignments, ok := currentData.Assignments[day]
-				if !ok {
-					dayAssignments = make(map[string]moira.Username)
-					currentData.Assignments[day] = dayAssignments
-				}
-				assignee, ok := dayAssignments[duty]
-				if ok && assignee != "" {
-					return errors.New("somebody else got this one already.")
-				}
-				dayAssignments[duty] = username
-				return nil
-			})
+			err := ErrPast
+			if !config.IsPast(day) {
+				err = transact(func(currentData *Data) error {
+					return Claim(currentData, username, duty, day)
+				})
+			}
+			if err == ErrNoDuty || err == ErrBadDay {
+				http.Error(w, fmt.Sprintf("Can't claim %q on %q: %v", duty, day, err), http.StatusBadRequest)
+				return
+			}
 			if err != nil {
+				logFor(r).Info("claim failed", "duty", duty, "day", day, "err", err)
 				break
 			}
-			log.Printf("%v claimed %v/%v", username, duty, day)
+			logFor(r).Info("claimed", "duty", duty, "day", day)
+			metrics.Lock()
+			metrics.claims++
+			metrics.Unlock()
 			break
 		}
 		if len(splitKey) == 3 && splitKey[0] == "abandon" {
 			duty := splitKey[1]
 			day := splitKey[2]
-			err := transact(func(currentData *Data) error {
-				dayAssignments, ok := currentData.Assignments[day]
-				if !ok {
-					return errors.New("not yours, no need to abandon it.")
-				}
-				assignee, ok := dayAssignments[duty]
-				if !ok || assignee != username {
-					return errors.New("not yours, no need to abandon it.")
-				}
-				dayAssignments[duty] = ""
-				return nil
-			})
+			err := ErrPast
+			if !config.IsPast(day) {
+				err = transact(func(currentData *Data) error {
+					return Abandon(currentData, username, duty, day)
+				})
+			}
+			if err == ErrNoDuty || err == ErrBadDay {
+				http.Error(w, fmt.Sprintf("Can't abandon %q on %q: %v", duty, day, err), http.StatusBadRequest)
+				return
+			}
 			if err != nil {
+				logFor(r).Info("abandon failed", "duty", duty, "day", day, "err", err)
 				break
 			}
 
-			log.Printf("%v abandoned %v/%v", username, duty, day)
+			logFor(r).Info("abandoned", "duty", duty, "day", day)
+			metrics.Lock()
+			metrics.abandons++
+			metrics.Unlock()
 
-			err = smtp.SendMail(
-				"outgoing.mit.edu:smtp",
-				nil,
-				"yfnkm@mit.edu",
-				[]string{"yfnkm@mit.edu", fmt.Sprint(username.Email())},
-				[]byte(fmt.Sprintf(`From: "pika kitchen website" <yfnkm@mit.edu>
-To: yfnkm@mit.edu
-Cc: %s
-Subject: %s unclaimed %v/%v -- eom
-
-`, username.Email(), username, duty, day)))
+			dataLock.Lock()
+			profiles, err := ReadProfiles(config.ProfilesFile)
+			dataLock.Unlock()
+			if err != nil {
+				logFor(r).Error("couldn't read profiles", "err", err)
+				profiles = Profiles{}
+			}
+			msg, err := NewAbandonEmail(config, profiles, username, duty, day)
+			if err == nil {
+				err = outbox.Enqueue(msg)
+			}
 			if err != nil {
-				log.Printf("%v", err)
+				logFor(r).Error("couldn't queue abandon email", "err", err)
+			}
+			if config.Webhook.Wants(AbandonEvent) {
+				// Don't make them wait for the chat server
+				go postAbandon(logFor(r), profiles.DisplayName(username), duty, day)
 			}
 
 			break
@@ -251,24 +514,23 @@ Subject: %s unclaimed %v/%v -- eom
 	}
 
 	// Display the main page again
-	http.Redirect(w, r, "/", http.StatusFound)
+	http.Redirect(w, r, windowURL("/", r), http.StatusFound)
 }
 
-// Authorizes the user as admin (must be on yfnkm or yfncc); aborts the request
-// with 403 Forbidden if not.  Returns whether authorization succeeded.
+// Authorizes the user as admin (must be on one of the configured admin lists, e.g. yfnkm or yfncc);
+// aborts the request with 403 Forbidden if not.  Returns whether authorization succeeded.
 func adminAuth(w http.ResponseWriter, r *http.Request) bool {
 	username := getAuthedUsername(r)
 	if username == "" {
 		http.Error(w, "No username", http.StatusUnauthorized)
 		return false
 	}
-	if err := moira.IsAuthorized("yfnkm", username); err == nil {
-		return true
-	}
-	if err := moira.IsAuthorized("yfncc", username); err == nil {
-		return true
+	for _, list := range config.AdminLists {
+		if err := moira.IsAuthorized(list, username); err == nil {
+			return true
+		}
 	}
-	http.Error(w, fmt.Sprintf("Not an admin (yfnkm or yfncc): %v", username), http.StatusForbidden)
+	http.Error(w, fmt.Sprintf("Not an admin (%v): %v", strings.Join(config.AdminLists, " or "), username), http.StatusForbidden)
 	return false
 }
 
@@ -279,101 +541,698 @@ func adminHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	t, err := template.ParseFiles("admin.html")
+	dataLock.Lock()
+	defer dataLock.Unlock()
+	currentData, err := ReadData(config.DataFile)
 	if err != nil {
-		handleErr(w, err)
+		handleErr(w, r, err)
 		return
 	}
-	dataLock.Lock()
-	defer dataLock.Unlock()
-	currentData, err := ReadData(DataFile)
+	window, err := windowFor(r, currentData.EndDate)
 	if err != nil {
-		handleErr(w, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	weeks, dayNames := makeWeeksAndDayNames(currentData.EndDate)
-	d := DisplayData{
+	d, err := adminDisplayData(currentData, window)
+	if err != nil {
+		handleErr(w, r, err)
+		return
+	}
+	err = renderPage(w, "admin.html", d)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func adminDisplayData(currentData *Data, window Window) (DisplayData, error) {
+	profiles, err := ReadProfiles(config.ProfilesFile)
+	if err != nil {
+		return DisplayData{}, err
+	}
+	weeks, dayNames := makeWeeksAndDayNames(window)
+	return DisplayData{
 		Duties:      currentData.Duties,
+		WeekDuties:  weekDuties(currentData, weeks),
 		Authorized:  true,
 		Username:    "",
 		DayNames:    dayNames,
 		Weeks:       weeks,
 		Assignments: currentData.Assignments,
+		Names:       displayNames(profiles, currentData),
 		EndDate:     currentData.EndDate,
-		VersionID:   currentData.VersionID, // Store the version in a hidden field
+		VersionID:   currentData.VersionID,
+		Window:      window,
+		Today:       config.Now().Format(DateFormat),
+	}, nil
+}
+
+// This handler runs when the admin hits "Save" on the admin interface.
+//
+// Every cell on the page comes with the value it had when the page was loaded ("orig/duty/day"), and
+// only the cells the admin changed are saved. If somebody else changed one of them in the meantime
+// (say, by claiming it), that one isn't saved, so nobody's claim gets overwritten by accident; the
+// page is shown again with those cells marked, so the admin can decide what to do. (It used to
+// refuse to save anything at all if anything had changed. That saved my ass at least once, but lost
+// a lot of edits too.)
+func adminSaveHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminAuth(w, r) {
+		return
+	}
+
+	endDate := r.FormValue("endDate")
+	if _, err := time.Parse(DateFormat, endDate); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid date %v, please provide a date in YYYY-MM-DD format", endDate), http.StatusBadRequest)
+		return
 	}
-	err = t.Execute(w, d)
+	window, err := windowFor(r, endDate)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+
+	var conflicts map[string]map[string]*CellConflict
+	notes := []string{}
+	saved := []string{}
+	var currentData *Data
+	err = transact(func(data *Data) error {
+		currentData = data
+		if origEndDate := r.FormValue("origEndDate"); endDate != origEndDate {
+			if data.EndDate == origEndDate || data.EndDate == endDate {
+				data.EndDate = endDate
+			} else {
+				notes = append(notes, fmt.Sprintf("Didn't change the end date to %s, because somebody else changed it to %s in the meantime.", endDate, data.EndDate))
+			}
+		}
+		saved, conflicts = mergeAdminEdits(data, r.Form)
+		return nil
+	})
+	if err != nil {
+		handleErr(w, r, err)
+		return
+	}
+	conflicted := 0
+	for _, dayConflicts := range conflicts {
+		conflicted += len(dayConflicts)
+	}
+	logFor(r).Info("admin save", "saved", len(saved), "conflicts", conflicted)
+	if len(saved) != 0 {
+		audit(r, "admin-save", strings.Join(saved, ", "))
+	}
+
+	if len(conflicts) == 0 && len(notes) == 0 {
+		// Display the admin interface again
+		http.Redirect(w, r, windowURL("/admin", r), http.StatusFound)
+		return
+	}
+
+	dataLock.Lock()
+	defer dataLock.Unlock()
+	d, err := adminDisplayData(currentData, window)
+	if err != nil {
+		handleErr(w, r, err)
+		return
+	}
+	d.Conflicts = conflicts
+	d.Notes = append([]string{fmt.Sprintf("Saved %d changes.", len(saved))}, notes...)
+	if conflicted != 0 {
+		d.Notes = append(d.Notes, fmt.Sprintf("Didn't save %d changes, marked below, because somebody else changed the same cells in the meantime. Save again to overwrite their changes with yours, or change them back.", conflicted))
+	}
+	w.WriteHeader(http.StatusConflict)
+	if err := renderPage(w, "admin.html", d); err != nil {
+		logFor(r).Error("couldn't render conflicts", "err", err)
+	}
 }
 
-// This handler runs when the admin hits "Save" on the admin interface.
-func adminSaveHandler(w http.ResponseWriter, r *http.Request) {
+// Make the changes to the cells in the admin page's form (see adminSaveHandler), except the ones
+// somebody else changed in the meantime, which are returned as conflicts. Also returns what was
+// changed, for the audit log.
+func mergeAdminEdits(data *Data, form url.Values) ([]string, map[string]map[string]*CellConflict) {
+	saved := []string{}
+	conflicts := map[string]map[string]*CellConflict{}
+	for key, values := range form {
+		splitKey := strings.Split(key, "/")
+		if len(splitKey) != 3 || splitKey[0] != "assignee" || len(values) == 0 {
+			continue
+		}
+		duty, day := splitKey[1], splitKey[2]
+		if _, err := time.Parse(DateFormat, day); err != nil {
+			continue
+		}
+		origValues, ok := form["orig/"+duty+"/"+day]
+		if !ok || len(origValues) == 0 || !data.IsActiveDuty(duty) {
+			// (e.g. the duty was renamed or retired in the meantime)
+			continue
+		}
+		yours, was := moira.Username(strings.TrimSpace(values[0])), moira.Username(origValues[0])
+		if yours == was {
+			continue
+		}
+		theirs := data.Assignments[day][duty]
+		if theirs != was && theirs != yours {
+			if conflicts[day] == nil {
+				conflicts[day] = map[string]*CellConflict{}
+			}
+			conflicts[day][duty] = &CellConflict{Was: was, Yours: yours, Theirs: theirs}
+			continue
+		}
+		if theirs != yours {
+			saved = append(saved, fmt.Sprintf("%s on %s: %q -> %q", duty, day, theirs, yours))
+		}
+		if data.Assignments[day] == nil {
+			data.Assignments[day] = map[string]moira.Username{}
+		}
+		data.Assignments[day][duty] = yours
+	}
+	sort.Strings(saved)
+	return saved, conflicts
+}
+
+// Record something an admin did in the audit log.
+func audit(r *http.Request, action, details string) {
+	err := AppendAudit(config.AuditLogFile, AuditEntry{User: getAuthedUsername(r), Action: action, Details: details})
+	if err != nil {
+		logFor(r).Error("couldn't write to the audit log", "err", err)
+	}
+}
+
+// The data type which will be passed to the duty editor template (adminDuties.html).
+type DutiesData struct {
+	Duties    []string
+	Retired   []string
+	RetiredOn map[string]string // when each retired duty was retired, if we know
+	Counts    map[string]int    // how many assignments each duty has
+	Warnings  []string
+	Error     string // why the last change didn't work, if it didn't
+}
+
+// This handler displays the duty editor, and makes the changes the admin asks for: add, rename,
+// move up or down, retire (take off the signup sheet, keeping its history) and restore.
+func adminDutiesHandler(w http.ResponseWriter, r *http.Request) {
 	if !adminAuth(w, r) {
 		return
 	}
 
+	if r.Method == "POST" {
+		action := r.FormValue("action")
+		duty := r.FormValue("duty")
+		newName := strings.TrimSpace(r.FormValue("newName"))
+		details := fmt.Sprintf("%q", duty)
+		err := transact(func(data *Data) error {
+			switch action {
+			case "add":
+				details = fmt.Sprintf("%q", newName)
+				return AddDuty(data, newName)
+			case "rename":
+				details = fmt.Sprintf("%q -> %q", duty, newName)
+				return RenameDuty(data, duty, newName)
+			case "up":
+				return MoveDuty(data, duty, -1)
+			case "down":
+				return MoveDuty(data, duty, 1)
+			case "retire":
+				return RetireDuty(data, duty, config.Now().Format(DateFormat))
+			case "restore":
+				return RestoreDuty(data, duty)
+			}
+			return fmt.Errorf("unknown action %q", action)
+		})
+		if err != nil {
+			http.Redirect(w, r, "/adminDuties?"+url.Values{"error": {err.Error()}}.Encode(), http.StatusFound)
+			return
+		}
+		logFor(r).Info("changed duties", "action", action, "details", details)
+		audit(r, action+"-duty", details)
+		http.Redirect(w, r, "/adminDuties", http.StatusFound)
+		return
+	}
+
 	dataLock.Lock()
 	defer dataLock.Unlock()
-	currentData, err := ReadData(DataFile)
+	currentData, err := ReadData(config.DataFile)
 	if err != nil {
-		handleErr(w, err)
+		handleErr(w, r, err)
 		return
 	}
-	// Compare the current version string with the version string stored in a hidden field when the
-	// page was originally displayed. If there has been a change in the meantime, abort -- this could
-	// lead to overwriting duties that other people claimed (since the entire state gets overwritten
-	// with the contents of the textboxes on the page). This has saved my ass at least once!
-	oldversion := r.FormValue("oldversion")
-	if got, want := oldversion, currentData.VersionID; got != want {
-		http.Error(w, fmt.Sprintf("Not up to date! Got %v, wanted %v", got, want), http.StatusConflict)
+	d := DutiesData{
+		Duties:    currentData.Duties,
+		Retired:   currentData.RetiredDuties,
+		RetiredOn: currentData.RetiredOn,
+		Counts:    map[string]int{},
+		Error:     r.FormValue("error"),
+	}
+	for _, dayAssignments := range currentData.Assignments {
+		for duty, assignee := range dayAssignments {
+			if assignee != "" && assignee != "_" {
+				d.Counts[duty]++
+			}
+		}
+	}
+	// The config file refers to duties by name too, and renaming them here doesn't change it
+	for name, group := range config.ReminderGroups {
+		for _, duty := range group.Duties {
+			if !currentData.IsActiveDuty(duty) {
+				d.Warnings = append(d.Warnings, fmt.Sprintf("Reminder group %q in the config file has %q, which isn't on the signup sheet.", name, duty))
+			}
+		}
+	}
+	for duty := range config.DutyDescriptions {
+		if !currentData.HasDuty(duty) {
+			d.Warnings = append(d.Warnings, fmt.Sprintf("DutyDescriptions in the config file describes %q, which isn't a duty.", duty))
+		}
+	}
+	for duty := range config.DutyTimes {
+		if !currentData.HasDuty(duty) {
+			d.Warnings = append(d.Warnings, fmt.Sprintf("DutyTimes in the config file has a time for %q, which isn't a duty.", duty))
+		}
+	}
+	sort.Strings(d.Warnings)
+	if err := renderPage(w, "adminDuties.html", d); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// The data type which will be passed to the bulk operations template (adminBulk.html).
+type BulkData struct {
+	Op          BulkOp
+	Duties      []string
+	Selected    map[string]bool // which duties Op applies to
+	Weekdays    []time.Weekday
+	Previewed   bool
+	Changes     []BulkChange
+	Fingerprint string // of Changes, so applying can check nothing changed since the preview
+	Error       string
+}
+
+func bulkOpFromForm(r *http.Request) BulkOp {
+	op := BulkOp{
+		Kind:        r.FormValue("kind"),
+		From:        strings.TrimSpace(r.FormValue("from")),
+		To:          strings.TrimSpace(r.FormValue("to")),
+		Duties:      r.Form["duty"],
+		Target:      strings.TrimSpace(r.FormValue("target")),
+		User:        moira.Username(strings.TrimSpace(r.FormValue("user"))),
+		Replacement: moira.Username(strings.TrimSpace(r.FormValue("replacement"))),
+		N:           1,
+	}
+	if weekday, err := strconv.Atoi(r.FormValue("weekday")); err == nil && weekday >= 0 && weekday < 7 {
+		op.Weekday = time.Weekday(weekday)
+	}
+	if n, err := strconv.Atoi(r.FormValue("n")); err == nil {
+		op.N = n
+	}
+	return op
+}
+
+var errChangedSincePreview = errors.New("the schedule changed since the preview, so nothing was done. Check the new preview and try again.")
+
+// This handler does things to many cells of the schedule at once (see BulkOp): clear or close a
+// range, copy a week, assign somebody on every Nth weekday, or replace somebody with somebody else.
+// "Preview" shows what would change; "Apply" does it, all in one transaction, as long as it's still
+// exactly what the preview showed.
+func adminBulkHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminAuth(w, r) {
 		return
 	}
+	r.ParseForm()
 
-	endDate := r.FormValue("endDate")
-	_, err = time.Parse(DateFormat, endDate)
+	d := BulkData{Op: bulkOpFromForm(r), Selected: map[string]bool{}}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		d.Weekdays = append(d.Weekdays, day)
+	}
+	for _, duty := range d.Op.Duties {
+		d.Selected[duty] = true
+	}
+	if d.Op.From == "" {
+		today := config.Now()
+		d.Op.From = today.Format(DateFormat)
+		d.Op.To = today.AddDate(0, 0, 6).Format(DateFormat)
+	}
+
+	if r.Method == "POST" && r.FormValue("action") == "apply" {
+		var changes []BulkChange
+		err := transact(func(data *Data) error {
+			var err error
+			if changes, err = d.Op.Changes(data); err != nil {
+				return err
+			}
+			if ChangesFingerprint(changes) != r.FormValue("fingerprint") {
+				return errChangedSincePreview
+			}
+			ApplyChanges(data, changes)
+			return nil
+		})
+		if err == nil {
+			logFor(r).Info("bulk change", "kind", d.Op.Kind, "changes", len(changes))
+			audit(r, "bulk-"+d.Op.Kind, fmt.Sprintf("%+v: %d changes", d.Op, len(changes)))
+			http.Redirect(w, r, "/admin", http.StatusFound)
+			return
+		}
+		d.Error = err.Error()
+		// Show the preview again
+	}
+
+	dataLock.Lock()
+	defer dataLock.Unlock()
+	currentData, err := ReadData(config.DataFile)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Invalid date %v, please provide a date in YYYY-MM-DD format", endDate), http.StatusBadRequest)
+		handleErr(w, r, err)
 		return
 	}
-	currentData.EndDate = endDate
+	d.Duties = currentData.Duties
+	if r.Method == "POST" {
+		changes, err := d.Op.Changes(currentData)
+		if err != nil {
+			d.Error = err.Error()
+		} else {
+			d.Previewed = true
+			d.Changes = changes
+			d.Fingerprint = ChangesFingerprint(changes)
+		}
+	}
+	if err := renderPage(w, "adminBulk.html", d); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
 
-	duties := r.FormValue("duties")
-	if strings.Contains(duties, "/") {
-		http.Error(w, "Duties can't contain slashes", http.StatusBadRequest)
+// The data type which will be passed to the spreadsheet template (adminSpreadsheet.html).
+type SpreadsheetData struct {
+	From, To    string // the range to export, to start with
+	Previewed   bool
+	Changes     []BulkChange
+	Table       string // what was uploaded, as CSV, to be applied once it's been previewed
+	Fingerprint string // of Changes (see adminBulkHandler)
+	Error       string
+}
+
+// This handler downloads the assignments from one day to another as a spreadsheet (see
+// AssignmentRows), in either layout, as CSV or XLSX.
+func adminExportHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminAuth(w, r) {
 		return
 	}
-	currentData.Duties = []string{}
-	for _, duty := range strings.Split(duties, ",") {
-		duty = strings.TrimSpace(duty)
-		currentData.Duties = append(currentData.Duties, duty)
+	from, to := r.FormValue("from"), r.FormValue("to")
+	layout, format := r.FormValue("layout"), r.FormValue("format")
+
+	dataLock.Lock()
+	currentData, err := ReadData(config.DataFile)
+	dataLock.Unlock()
+	if err != nil {
+		handleErr(w, r, err)
+		return
+	}
+	rows, err := AssignmentRows(currentData, from, to, layout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	var buf bytes.Buffer
+	if err := WriteTable(&buf, rows, format); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	contentType := "text/csv; charset=utf-8"
+	if format == XLSXFormat {
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="mealplan-%s-to-%s-%s.%s"`, from, to, layout, format))
+	w.Write(buf.Bytes())
+}
 
-	_, dayNames := makeWeeksAndDayNames(currentData.EndDate)
-	for day, _ := range dayNames {
-		dayAssignments, ok := currentData.Assignments[day]
-		if !ok {
-			dayAssignments = make(map[string]moira.Username)
-			currentData.Assignments[day] = dayAssignments
+// The biggest spreadsheet which can be uploaded.
+const maxUploadSize = 10 << 20
+
+// This handler shows the export form, and imports spreadsheets in the same formats (see ImportRows):
+// uploading one shows what it would change, and "Apply" changes it, in one transaction, as long as
+// that's still exactly what the preview showed.
+func adminSpreadsheetHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminAuth(w, r) {
+		return
+	}
+
+	dataLock.Lock()
+	currentData, err := ReadData(config.DataFile)
+	dataLock.Unlock()
+	if err != nil {
+		handleErr(w, r, err)
+		return
+	}
+	today := config.Now()
+	d := SpreadsheetData{
+		From: MondayOf(today).Format(DateFormat),
+		To:   currentData.EndDate,
+	}
+	if r.Method != "POST" {
+		if err := renderPage(w, "adminSpreadsheet.html", d); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
-		for _, duty := range currentData.Duties {
-			if values, ok := r.Form[fmt.Sprintf("assignee/%v/%v", duty, day)]; ok && len(values) != 0 {
-				dayAssignments[duty] = moira.Username(values[0])
+		return
+	}
+
+	var rows [][]string
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if r.FormValue("action") == "apply" {
+		rows, err = ReadTable([]byte(r.FormValue("table")))
+	} else {
+		var file io.ReadCloser
+		if file, _, err = r.FormFile("file"); err == nil {
+			var contents []byte
+			contents, err = io.ReadAll(file)
+			file.Close()
+			if err == nil {
+				rows, err = ReadTable(contents)
 			}
 		}
 	}
-	if err = WriteData(DataFile, currentData); err != nil {
-		handleErr(w, err)
+	if err != nil {
+		d.Error = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		if err := renderPage(w, "adminSpreadsheet.html", d); err != nil {
+			logFor(r).Error("couldn't render spreadsheet page", "err", err)
+		}
 		return
 	}
 
-	// Display the admin interface again
-	http.Redirect(w, r, "/admin", http.StatusFound)
+	var changes []BulkChange
+	if r.FormValue("action") == "apply" {
+		err = transact(func(data *Data) error {
+			var err error
+			if changes, err = ImportRows(data, rows); err != nil {
+				return err
+			}
+			if ChangesFingerprint(changes) != r.FormValue("fingerprint") {
+				return errChangedSincePreview
+			}
+			ApplyChanges(data, changes)
+			return nil
+		})
+		if err == nil {
+			logFor(r).Info("imported spreadsheet", "changes", len(changes))
+			audit(r, "import-spreadsheet", fmt.Sprintf("%d changes", len(changes)))
+			http.Redirect(w, r, "/admin", http.StatusFound)
+			return
+		}
+		d.Error = err.Error()
+		// Show the preview again
+		dataLock.Lock()
+		currentData, err = ReadData(config.DataFile)
+		dataLock.Unlock()
+		if err != nil {
+			handleErr(w, r, err)
+			return
+		}
+	}
+
+	if changes, err = ImportRows(currentData, rows); err != nil {
+		d.Error = err.Error()
+	} else {
+		var table bytes.Buffer
+		WriteTable(&table, rows, CSVFormat)
+		d.Previewed = true
+		d.Changes = changes
+		d.Table = table.String()
+		d.Fingerprint = ChangesFingerprint(changes)
+	}
+	if err := renderPage(w, "adminSpreadsheet.html", d); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
 }
 
-/* stats page is disabled for now, you'll need to do some refactoring if you want to bring it back 
+// The data type which will be passed to the profile template (me.html).
+type MeData struct {
+	Username      moira.Username
+	Profile       Profile
+	Notifications []NotificationChoice
+	Reminders     []ReminderChoice
+	Saved         bool
+	ViewingAs     moira.Username // the admin looking at the page as Username, if any (see viewAs)
+}
+
+// How the user gets one kind of notification, and how they could.
+type NotificationChoice struct {
+	Kind, Description string
+	Channel           string
+	Channels          []string
+}
+
+// Whether the user wants the reminders sent DaysBefore days ahead of their shifts.
+type ReminderChoice struct {
+	DaysBefore int
+	Name       string
+	Wanted     bool
+}
+
+// The different numbers of days ahead of shifts reminders get sent, earliest first.
+func reminderLeadTimes() []int {
+	seen := map[int]bool{}
+	leadTimes := []int{}
+	for _, rule := range config.ReminderRules {
+		if !seen[rule.DaysBefore] {
+			seen[rule.DaysBefore] = true
+			leadTimes = append(leadTimes, rule.DaysBefore)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(leadTimes)))
+	return leadTimes
+}
+
+func leadTimeName(daysBefore int) string {
+	switch daysBefore {
+	case 0:
+		return "the day of the shift"
+	case 1:
+		return "the day before"
+	default:
+		return fmt.Sprintf("%d days before", daysBefore)
+	}
+}
+
+// Makes sure there is a profile for the logged-in user, filling it in from their certificate the
+// first time we see them.
+func ensureProfile(r *http.Request) error {
+	username := getAuthedUsername(r)
+	if username == "" {
+		return nil
+	}
+	dataLock.Lock()
+	defer dataLock.Unlock()
+	profiles, err := ReadProfiles(config.ProfilesFile)
+	if err != nil {
+		return err
+	}
+	if _, ok := profiles[username]; ok {
+		return nil
+	}
+	profiles[username] = &Profile{
+		DisplayName: r.Header.Get("proxy-authenticated-full-name"),
+		Email:       moira.Email(r.Header.Get("proxy-authenticated-email")),
+	}
+	logFor(r).Info("created profile", "name", profiles[username].DisplayName)
+	return WriteProfiles(config.ProfilesFile, profiles)
+}
+
+// This handler displays the user's own profile, and saves it when they submit the form.
+func meHandler(w http.ResponseWriter, r *http.Request) {
+	username, admin, ok := viewAs(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == "POST" {
+		email := strings.TrimSpace(r.FormValue("email"))
+		if email != "" {
+			// (it goes into the To: header of the reminders, so no sneaking in more headers)
+			address, err := mail.ParseAddress(email)
+			if err != nil || strings.ContainsAny(email, "\r\n") {
+				http.Error(w, fmt.Sprintf("Invalid email address %q", email), http.StatusBadRequest)
+				return
+			}
+			email = address.Address
+		}
+		dataLock.Lock()
+		defer dataLock.Unlock()
+		profiles, err := ReadProfiles(config.ProfilesFile)
+		if err != nil {
+			handleErr(w, r, err)
+			return
+		}
+		profile, ok := profiles[username]
+		if !ok {
+			profile = &Profile{}
+			profiles[username] = profile
+		}
+		profile.DisplayName = strings.TrimSpace(r.FormValue("displayName"))
+		profile.PreferredName = strings.TrimSpace(r.FormValue("preferredName"))
+		profile.Email = moira.Email(email)
+
+		profile.Notifications = map[string]string{}
+		for _, kind := range NotificationKinds {
+			channel := r.FormValue("notify-" + kind.Kind)
+			if !ValidChannel(channel) {
+				http.Error(w, fmt.Sprintf("Invalid channel %q for %s", channel, kind.Kind), http.StatusBadRequest)
+				return
+			}
+			if channel != EmailChannel {
+				profile.Notifications[kind.Kind] = channel
+			}
+		}
+		profile.ReminderDaysBefore = nil
+		leadTimes := reminderLeadTimes()
+		for _, daysBefore := range leadTimes {
+			if r.FormValue(fmt.Sprintf("remind-%d", daysBefore)) != "" {
+				profile.ReminderDaysBefore = append(profile.ReminderDaysBefore, daysBefore)
+			}
+		}
+		if len(profile.ReminderDaysBefore) == 0 && profile.Notifications[ReminderNotification] != NoChannel && len(leadTimes) != 0 {
+			http.Error(w, "Pick at least one reminder to get, or turn reminders off", http.StatusBadRequest)
+			return
+		}
+		if len(profile.ReminderDaysBefore) == len(leadTimes) {
+			// Wanting all of them is the default, which includes any reminders added later
+			profile.ReminderDaysBefore = nil
+		}
+		if err := WriteProfiles(config.ProfilesFile, profiles); err != nil {
+			handleErr(w, r, err)
+			return
+		}
+		logFor(r).Info("updated profile")
+		http.Redirect(w, r, "/me?saved=1", http.StatusFound)
+		return
+	}
+
+	dataLock.Lock()
+	defer dataLock.Unlock()
+	profiles, err := ReadProfiles(config.ProfilesFile)
+	if err != nil {
+		handleErr(w, r, err)
+		return
+	}
+	d := MeData{Username: username, Saved: r.FormValue("saved") != "", ViewingAs: admin}
+	if profile, ok := profiles[username]; ok {
+		d.Profile = *profile
+	}
+	for _, kind := range NotificationKinds {
+		d.Notifications = append(d.Notifications, NotificationChoice{
+			Kind:        kind.Kind,
+			Description: kind.Description,
+			Channel:     profiles.Channel(username, kind.Kind),
+			Channels:    NotificationChannels,
+		})
+	}
+	for _, daysBefore := range reminderLeadTimes() {
+		d.Reminders = append(d.Reminders, ReminderChoice{
+			DaysBefore: daysBefore,
+			Name:       leadTimeName(daysBefore),
+			Wanted:     d.Profile.WantsReminderDaysBefore(daysBefore),
+		})
+	}
+	err = renderPage(w, "me.html", d)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
 
 type Signup struct {
 	Date, Duty string
@@ -382,6 +1241,7 @@ type Signup struct {
 type PersonStats struct {
 	Signups  []Signup
 	Username moira.Username
+	Name     string
 }
 
 type BySignupCount []PersonStats
@@ -398,31 +1258,42 @@ func (s BySignupCount) Less(i, j int) bool {
 
 type StatsData struct {
 	People []PersonStats
-	Since  time.Time
+	Since  string
 }
 
+// This handler displays how many duties each person has signed up for since a given date
+// (?since=YYYY-MM-DD, by default three months ago).
 func adminStatsHandler(w http.ResponseWriter, r *http.Request) {
 	if !adminAuth(w, r) {
 		return
 	}
 
-	t, err := template.ParseFiles("stats.html")
+	since := r.FormValue("since")
+	if since == "" {
+		since = config.Now().AddDate(0, -3, 0).Format(DateFormat)
+	}
+	if _, err := time.Parse(DateFormat, since); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid date %v, please provide a date in YYYY-MM-DD format", since), http.StatusBadRequest)
+		return
+	}
+
+	authorize := r.Header.Get("proxy-authorized-list")
+	users, err := moira.GetMoiraNFSGroupMembers(authorize)
 	if err != nil {
-		handleErr(w, err)
+		handleErr(w, r, err)
 		return
 	}
+
 	dataLock.Lock()
 	defer dataLock.Unlock()
-	currentData, err := ReadData(DataFile)
+	currentData, err := ReadData(config.DataFile)
 	if err != nil {
-		handleErr(w, err)
+		handleErr(w, r, err)
 		return
 	}
-
-	authorize := r.Header.Get("proxy-authorized-list")
-	users, err := moira.GetMoiraNFSGroupMembers(authorize)
+	profiles, err := ReadProfiles(config.ProfilesFile)
 	if err != nil {
-		handleErr(w, err)
+		handleErr(w, r, err)
 		return
 	}
 
@@ -430,53 +1301,62 @@ func adminStatsHandler(w http.ResponseWriter, r *http.Request) {
 	for _, u := range users {
 		stats[u] = PersonStats{Signups: []Signup{}, Username: u}
 	}
-
-	mealplanStartDate := mealplanStartDate()
-	dbStartDate, _ := GetDateRange()
-	for dayindex, dayname := range currentData.Days {
-		date := dbStartDate.AddDate(0, 0, dayindex)
-		if date.Equal(mealplanStartDate) || date.After(mealplanStartDate) {
-			for _, duty := range Duties {
-				if dayindex < len(currentData.Assignments[duty]) {
-					u := currentData.Assignments[duty][dayindex]
-					if u != "" && u != "_" {
-						stats[u] = PersonStats{append(stats[u].Signups, Signup{dayname, duty}), u}
-					}
-				}
+	for day, dayAssignments := range currentData.Assignments {
+		// Dates are formatted YYYY-MM-DD, so they compare correctly as strings
+		if day < since {
+			continue
+		}
+		for duty, u := range dayAssignments {
+			if u != "" && u != "_" {
+				stats[u] = PersonStats{Signups: append(stats[u].Signups, Signup{day, duty}), Username: u}
 			}
 		}
 	}
 
-	d := StatsData{People: []PersonStats{}, Since: mealplanStartDate}
-	for _, s := range stats {
-		// if len(s.Signups) != 0 {
+	d := StatsData{People: []PersonStats{}, Since: since}
+	for u, s := range stats {
+		s.Name = profiles.DisplayName(u)
+		sort.Slice(s.Signups, func(i, j int) bool { return s.Signups[i].Date < s.Signups[j].Date })
 		d.People = append(d.People, s)
 	}
 	sort.Sort(BySignupCount(d.People))
 
-	err = t.Execute(w, d)
+	err = renderPage(w, "stats.html", d)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
-*/
 
 // This is the overall handler which decides, for authorized users, which page to display.
 func getHandler() http.Handler {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", signupHandler)
-	mux.HandleFunc("/claim", claimHandler)
-	mux.HandleFunc("/admin", adminHandler)
-	mux.HandleFunc("/adminSave", adminSaveHandler)
-//	mux.HandleFunc("/stats", adminStatsHandler)
-	return mux
+	mux.HandleFunc("/", instrument("signup", signupHandler))
+	mux.HandleFunc("/claim", instrument("claim", claimHandler))
+	mux.HandleFunc("/admin", instrument("admin", adminHandler))
+	mux.HandleFunc("/adminSave", instrument("adminSave", adminSaveHandler))
+	mux.HandleFunc("/adminDuties", instrument("adminDuties", adminDutiesHandler))
+	mux.HandleFunc("/adminBulk", instrument("adminBulk", adminBulkHandler))
+	mux.HandleFunc("/adminExport", instrument("adminExport", adminExportHandler))
+	mux.HandleFunc("/adminSpreadsheet", instrument("adminSpreadsheet", adminSpreadsheetHandler))
+	mux.HandleFunc("/stats", instrument("stats", adminStatsHandler))
+	mux.HandleFunc("/me", instrument("me", meHandler))
+	mux.HandleFunc("/print", instrument("print", printHandler))
+	// (not instrumented, since it stays open as long as the page does)
+	mux.HandleFunc("/events", eventsHandler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := ensureProfile(r); err != nil {
+			logFor(r).Error("couldn't create profile", "err", err)
+		}
+		mux.ServeHTTP(w, r)
+	})
 }
 
 // This is the overall handler for unauthorized users. It always displays the unauthorized
 // interface.
 func getUnauthHandler() http.Handler {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", unauthHandler)
+	mux.HandleFunc("/", instrument("unauth", unauthHandler))
+	mux.HandleFunc("/events", eventsHandler)
 	return mux
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	. "github.com/pikans/mealplan"
+	"github.com/pikans/mealplan/moira"
+)
+
+func TestMergeAdminEdits(t *testing.T) {
+	data := &Data{
+		Assignments: map[string]map[string]moira.Username{
+			"2026-10-19": {"Big Cook": "alice", "Little Cook": "bob"},
+			"2026-10-20": {"Big Cook": "carol", "Little Cook": "erin"},
+			"2026-10-22": {"Little Cook": "gina"},
+		},
+		Duties:        []string{"Big Cook", "Little Cook"},
+		RetiredDuties: []string{"Old Duty"},
+	}
+	// The page was loaded when Big Cook on the 20th was still open, and Little Cook was dave
+	form := url.Values{
+		// Not changed on the page, so not saved, even though it's different now
+		"orig/Big Cook/2026-10-19": {"alice"}, "assignee/Big Cook/2026-10-19": {"alice"},
+		// Changed on the page, and still as it was, so saved
+		"orig/Little Cook/2026-10-19": {"bob"}, "assignee/Little Cook/2026-10-19": {" _ "},
+		"orig/Big Cook/2026-10-21": {""}, "assignee/Big Cook/2026-10-21": {"frank"},
+		// Changed on the page and by somebody else, so both are conflicts...
+		"orig/Big Cook/2026-10-20": {""}, "assignee/Big Cook/2026-10-20": {"frank"},
+		"orig/Little Cook/2026-10-20": {"dave"}, "assignee/Little Cook/2026-10-20": {""},
+		// ...unless they made the same change
+		"orig/Little Cook/2026-10-22": {""}, "assignee/Little Cook/2026-10-22": {"gina"},
+		// Not cells which can be changed
+		"orig/Old Duty/2026-10-19": {""}, "assignee/Old Duty/2026-10-19": {"frank"},
+		"orig/Big Cook/soon": {""}, "assignee/Big Cook/soon": {"frank"},
+		"assignee/Little Cook/2026-10-23": {"frank"},
+	}
+	saved, conflicts := mergeAdminEdits(data, form)
+
+	wantSaved := []string{
+		`Big Cook on 2026-10-21: "" -> "frank"`,
+		`Little Cook on 2026-10-19: "bob" -> "_"`,
+	}
+	if !reflect.DeepEqual(saved, wantSaved) {
+		t.Errorf("saved %q, want %q", saved, wantSaved)
+	}
+	wantConflicts := map[string]map[string]*CellConflict{
+		"2026-10-20": {
+			"Big Cook":    {Was: "", Yours: "frank", Theirs: "carol"},
+			"Little Cook": {Was: "dave", Yours: "", Theirs: "erin"},
+		},
+	}
+	if !reflect.DeepEqual(conflicts, wantConflicts) {
+		t.Errorf("conflicts are %v, want %v", conflicts, wantConflicts)
+	}
+	wantAssignments := map[string]map[string]moira.Username{
+		"2026-10-19": {"Big Cook": "alice", "Little Cook": "_"},
+		"2026-10-20": {"Big Cook": "carol", "Little Cook": "erin"},
+		"2026-10-21": {"Big Cook": "frank"},
+		"2026-10-22": {"Little Cook": "gina"},
+	}
+	if !reflect.DeepEqual(data.Assignments, wantAssignments) {
+		t.Errorf("assignments are now %v, want %v", data.Assignments, wantAssignments)
+	}
+}
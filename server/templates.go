@@ -0,0 +1,108 @@
+package main
+
+import (
+	"embed"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+
+	. "github.com/pikans/mealplan"
+	"github.com/pikans/mealplan/moira"
+)
+
+// The HTML templates are built into the binary, so deploying is just copying the binary.
+//
+//go:embed templates/*.html
+var embeddedTemplates embed.FS
+
+var templatesDir = flag.String("templates", "", "(development) directory to re-read the HTML templates from on every request, instead of using the built-in ones")
+
+// The pages which can be displayed. Each gets parsed along with layout.html and grid.html.
+var pageNames = []string{"signup.html", "admin.html", "stats.html", "me.html", "confirm.html", "adminDuties.html", "adminBulk.html", "adminSpreadsheet.html", "print.html"}
+
+// What the grid (grid.html) passes to each page's "cell" template.
+type Cell struct {
+	Data     DisplayData
+	Duty     string
+	Day      string
+	Assignee moira.Username
+	Past     bool // whether the day is over (see Config.IsPast)
+	Retired  bool // whether the duty has been taken off the signup sheet (so it's only shown for history)
+}
+
+var templateFuncs = template.FuncMap{
+	"dayName": LongDayName,
+	"dutyTime": func(duty string) string {
+		return config.DutyTimes[duty]
+	},
+	"cell": func(d DisplayData, duty, day string) Cell {
+		retired := true
+		for _, active := range d.Duties {
+			if active == duty {
+				retired = false
+			}
+		}
+		return Cell{Data: d, Duty: duty, Day: day, Assignee: d.Assignments[day][duty], Past: day < d.Today, Retired: retired}
+	},
+}
+
+// The parsed templates, by page name. Parsed once at startup, unless -templates is given.
+var pages map[string]*template.Template
+
+func parseTemplates(fsys fs.FS) (map[string]*template.Template, error) {
+	base, err := template.New("").Funcs(templateFuncs).ParseFS(fsys, "layout.html", "grid.html")
+	if err != nil {
+		return nil, err
+	}
+	parsed := map[string]*template.Template{}
+	for _, name := range pageNames {
+		t, err := base.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if parsed[name], err = t.ParseFS(fsys, name); err != nil {
+			return nil, err
+		}
+	}
+	return parsed, nil
+}
+
+// Parse the templates, either from the directory given by -templates (or the config file) or from
+// the ones built into the binary.
+func loadTemplates() error {
+	if *templatesDir == "" {
+		*templatesDir = config.TemplateDir
+	}
+	var fsys fs.FS
+	if *templatesDir != "" {
+		fsys = os.DirFS(*templatesDir)
+	} else {
+		var err error
+		if fsys, err = fs.Sub(embeddedTemplates, "templates"); err != nil {
+			return err
+		}
+	}
+	var err error
+	pages, err = parseTemplates(fsys)
+	return err
+}
+
+// Render a page. When developing with -templates, the templates are re-read first so changes show
+// up without restarting.
+func renderPage(w http.ResponseWriter, name string, data interface{}) error {
+	ts := pages
+	if *templatesDir != "" {
+		var err error
+		if ts, err = parseTemplates(os.DirFS(*templatesDir)); err != nil {
+			return err
+		}
+	}
+	t, ok := ts[name]
+	if !ok {
+		return fmt.Errorf("no such page %q", name)
+	}
+	return t.ExecuteTemplate(w, "layout", data)
+}
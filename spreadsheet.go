@@ -0,0 +1,241 @@
+package mealplan
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pikans/mealplan/moira"
+)
+
+// The assignments as a table, for the spreadsheets people keep alongside the signup sheet (as CSV or
+// XLSX files; see WriteTable and ReadTable). There are two layouts: wide, with a row for each
+// day and a column for each duty, like the signup sheet turned on its side, and long, with a row
+// for each assignment (day, duty, user), which is easier to add up. Closed duties are "_", as on
+// the admin page. Both can be imported again (see ImportRows).
+
+const (
+	WideLayout = "wide"
+	LongLayout = "long"
+)
+
+// The file formats.
+const (
+	CSVFormat  = "csv"
+	XLSXFormat = "xlsx"
+)
+
+var longHeader = []string{"Day", "Duty", "User"}
+
+// The most days exported at once, to catch typos in the year.
+const maxExportDays = 3660
+
+// The assignments from one day to another (inclusive, YYYY-MM-DD) as rows of a table, starting with
+// a row of column names. The columns are the duties on the signup sheet, plus any retired ones with
+// assignments in the range.
+func AssignmentRows(data *Data, from, to, layout string) ([][]string, error) {
+	fromDate, err := time.Parse(DateFormat, from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start date %q", from)
+	}
+	toDate, err := time.Parse(DateFormat, to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end date %q", to)
+	}
+	days, err := dayRange(fromDate, toDate, maxExportDays)
+	if err != nil {
+		return nil, err
+	}
+
+	duties := append([]string{}, data.Duties...)
+	for _, duty := range data.RetiredDuties {
+		for _, day := range days {
+			if data.Assignments[day][duty] != "" {
+				duties = append(duties, duty)
+				break
+			}
+		}
+	}
+
+	switch layout {
+	case WideLayout:
+		rows := [][]string{append([]string{"Day"}, duties...)}
+		for _, day := range days {
+			row := []string{day}
+			for _, duty := range duties {
+				row = append(row, string(data.Assignments[day][duty]))
+			}
+			rows = append(rows, row)
+		}
+		return rows, nil
+	case LongLayout:
+		rows := [][]string{longHeader}
+		for _, day := range days {
+			for _, duty := range duties {
+				if assignee := data.Assignments[day][duty]; assignee != "" {
+					rows = append(rows, []string{day, duty, string(assignee)})
+				}
+			}
+		}
+		return rows, nil
+	default:
+		return nil, fmt.Errorf("unknown layout %q", layout)
+	}
+}
+
+// Read a day from a spreadsheet, which may have turned YYYY-MM-DD into M/D/YYYY, or (in XLSX) into a
+// number of days since the end of 1899.
+func parseSheetDay(s string) (string, error) {
+	for _, format := range []string{DateFormat, "1/2/2006", "1/2/06"} {
+		if date, err := time.Parse(format, s); err == nil {
+			return date.Format(DateFormat), nil
+		}
+	}
+	if serial, err := strconv.Atoi(s); err == nil && serial > 0 {
+		return time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC).AddDate(0, 0, serial).Format(DateFormat), nil
+	}
+	return "", fmt.Errorf("%q isn't a YYYY-MM-DD date", s)
+}
+
+// Work out what importing a table (in either layout, going by its first row) would change, without
+// changing anything. In the wide layout every cell counts, so an empty one unassigns the duty; in the
+// long layout, only the assignments listed change.
+func ImportRows(data *Data, rows [][]string) ([]BulkChange, error) {
+	// Tidy up the cells, and find the first row with anything in it, which should be the header
+	table := [][]string{}
+	start := -1
+	for i, row := range rows {
+		cells := []string{}
+		empty := true
+		for _, cell := range row {
+			cell = strings.TrimSpace(cell)
+			cells = append(cells, cell)
+			empty = empty && cell == ""
+		}
+		if empty {
+			cells = nil
+		} else if start == -1 {
+			start = i
+		}
+		table = append(table, cells)
+	}
+	if start == -1 {
+		return nil, fmt.Errorf("there's nothing in it")
+	}
+
+	header := table[start]
+	long := len(header) >= 3
+	for i, name := range longHeader {
+		long = long && strings.EqualFold(header[i], name)
+	}
+	if !long && (len(header) < 2 || !strings.EqualFold(header[0], "Day")) {
+		return nil, fmt.Errorf(`the first row should be column names: "Day" and then the duties, or "Day", "Duty" and "User"`)
+	}
+	if !long {
+		for _, duty := range header[1:] {
+			if duty != "" && !data.HasDuty(duty) {
+				return nil, fmt.Errorf("there's a column for %q, which isn't a duty", duty)
+			}
+		}
+	}
+
+	assignments := map[string]map[string]moira.Username{}
+	assign := func(rowNumber int, day, duty, assignee string) error {
+		if !data.HasDuty(duty) {
+			return fmt.Errorf("row %d: %q isn't a duty", rowNumber, duty)
+		}
+		day, err := parseSheetDay(day)
+		if err != nil {
+			return fmt.Errorf("row %d: %v", rowNumber, err)
+		}
+		if strings.ContainsAny(assignee, " \t/") {
+			return fmt.Errorf("row %d: %q isn't a username", rowNumber, assignee)
+		}
+		if assignments[day] == nil {
+			assignments[day] = map[string]moira.Username{}
+		}
+		if _, ok := assignments[day][duty]; ok {
+			return fmt.Errorf("row %d: %s on %s is in there more than once", rowNumber, duty, day)
+		}
+		assignments[day][duty] = moira.Username(assignee)
+		return nil
+	}
+	for i, row := range table[start+1:] {
+		rowNumber := start + i + 2
+		if row == nil {
+			continue
+		}
+		if long {
+			if len(row) < 3 {
+				row = append(row, make([]string, 3-len(row))...)
+			}
+			if err := assign(rowNumber, row[0], row[1], row[2]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for j, duty := range header[1:] {
+			assignee := ""
+			if j+1 < len(row) {
+				assignee = row[j+1]
+			}
+			if duty == "" {
+				continue
+			}
+			if err := assign(rowNumber, row[0], duty, assignee); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	days := []string{}
+	for day := range assignments {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	changes := []BulkChange{}
+	for _, day := range days {
+		for _, duty := range append(append([]string{}, data.Duties...), data.RetiredDuties...) {
+			assignee, ok := assignments[day][duty]
+			if old := data.Assignments[day][duty]; ok && old != assignee {
+				changes = append(changes, BulkChange{Day: day, Duty: duty, Old: old, New: assignee})
+			}
+		}
+	}
+	return changes, nil
+}
+
+// Write the rows out as a CSV or XLSX file.
+func WriteTable(w io.Writer, rows [][]string, format string) error {
+	switch format {
+	case CSVFormat:
+		writer := csv.NewWriter(w)
+		writer.WriteAll(rows)
+		return writer.Error()
+	case XLSXFormat:
+		return WriteXLSX(w, rows)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// Read the rows of a CSV or XLSX file, going by what's in it, since uploaded files don't always have
+// the right name.
+func ReadTable(contents []byte) ([][]string, error) {
+	if bytes.HasPrefix(contents, []byte("PK\x03\x04")) {
+		return ReadXLSX(bytes.NewReader(contents), int64(len(contents)))
+	}
+	// (Excel starts CSV files with a byte order mark)
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(contents, []byte("\ufeff"))))
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("not a CSV or XLSX file: %v", err)
+	}
+	return rows, nil
+}
@@ -0,0 +1,110 @@
+package mealplan
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pikans/mealplan/moira"
+)
+
+func sheetData() *Data {
+	return &Data{
+		Assignments: map[string]map[string]moira.Username{
+			"2026-10-19": {"Big Cook": "alice", "Little Cook": "_"},
+			"2026-10-20": {"Big Cook": "bob", "Old Duty": "carol"},
+		},
+		Duties:        []string{"Big Cook", "Little Cook"},
+		RetiredDuties: []string{"Old Duty"},
+	}
+}
+
+// Exporting and importing again (through an XLSX file) shouldn't change anything.
+func TestExportThenImport(t *testing.T) {
+	for _, layout := range []string{WideLayout, LongLayout} {
+		data := sheetData()
+		rows, err := AssignmentRows(data, "2026-10-18", "2026-10-21", layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := WriteTable(&buf, rows, XLSXFormat); err != nil {
+			t.Fatal(err)
+		}
+		rows, err = ReadTable(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		changes, err := ImportRows(data, rows)
+		if err != nil {
+			t.Fatalf("%s: %v", layout, err)
+		}
+		if len(changes) != 0 {
+			t.Errorf("%s: importing what was exported changes %v", layout, changes)
+		}
+	}
+}
+
+func TestImportRows(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		csv  string
+		want []BulkChange
+	}{
+		{
+			"wide, where an empty cell unassigns",
+			"\ufeffDay,Big Cook,Little Cook\n10/19/2026,dave,\n2026-10-21, erin ,_\n",
+			[]BulkChange{
+				{Day: "2026-10-19", Duty: "Big Cook", Old: "alice", New: "dave"},
+				{Day: "2026-10-19", Duty: "Little Cook", Old: "_", New: ""},
+				{Day: "2026-10-21", Duty: "Big Cook", Old: "", New: "erin"},
+				{Day: "2026-10-21", Duty: "Little Cook", Old: "", New: "_"},
+			},
+		},
+		{
+			"long, where only what's listed changes",
+			",,\nday,duty,user\n2026-10-20,Old Duty,\n2026-10-20,Big Cook,bob\n46315,Little Cook,frank\n",
+			[]BulkChange{
+				{Day: "2026-10-20", Duty: "Little Cook", Old: "", New: "frank"},
+				{Day: "2026-10-20", Duty: "Old Duty", Old: "carol", New: ""},
+			},
+		},
+	} {
+		rows, err := ReadTable([]byte(test.csv))
+		if err != nil {
+			t.Fatal(err)
+		}
+		changes, err := ImportRows(sheetData(), rows)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !reflect.DeepEqual(changes, test.want) {
+			t.Errorf("%s: changes are %v, want %v", test.name, changes, test.want)
+		}
+	}
+}
+
+func TestImportRowsBadInput(t *testing.T) {
+	for _, test := range []struct {
+		csv     string
+		wantErr string
+	}{
+		{"\n,\n", "nothing in it"},
+		{"Date,Big Cook\n", "first row should be column names"},
+		{"Day\n2026-10-19\n", "first row should be column names"},
+		{"Day,Head Chef\n2026-10-19,alice\n", `"Head Chef", which isn't a duty`},
+		{"Day,Duty,User\n2026-10-19,Head Chef,alice\n", `row 2: "Head Chef" isn't a duty`},
+		{"Day,Big Cook\nyesterday,alice\n", `row 2: "yesterday" isn't a YYYY-MM-DD date`},
+		{"Day,Big Cook\n2026-10-19,Alice Smith\n", `row 2: "Alice Smith" isn't a username`},
+		{"Day,Duty,User\n2026-10-19,Big Cook,alice\n10/19/2026,Big Cook,bob\n", "row 3: Big Cook on 2026-10-19 is in there more than once"},
+	} {
+		rows, err := ReadTable([]byte(test.csv))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ImportRows(sheetData(), rows)
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("importing %q: got error %v, want one about %q", test.csv, err, test.wantErr)
+		}
+	}
+}
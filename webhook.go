@@ -0,0 +1,100 @@
+package mealplan
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"text/template"
+	"time"
+)
+
+// Posting to a chat server (Zulip, Slack, Mattermost, Matrix with a hookshot bridge...) through an
+// incoming webhook: an HTTP POST of a JSON body made from Webhook.Template.
+
+// The kinds of events which can be posted.
+const (
+	AbandonEvent  = "abandon"  // somebody abandoned a duty
+	UnfilledEvent = "unfilled" // an unfilled-shift alert (see UnfilledAlerts)
+	CrewEvent     = "crew"     // who's on duty tonight, posted daily at Webhook.CrewAt
+)
+
+// What gets posted: Template is executed with this.
+type WebhookEvent struct {
+	Event string // one of the *Event constants
+	Text  string // a message describing it, in Markdown (which most chat servers understand)
+	Day   string
+	URL   string // a link to act on it, e.g. to claim the open duty, if there is one
+}
+
+// Slack's incoming webhook format, which Zulip's and Mattermost's also accept.
+const DefaultWebhookTemplate = `{"text": {{json .Text}}}`
+
+var webhookFuncs = template.FuncMap{
+	// Quote a string for JSON
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func (w WebhookConfig) template() (*template.Template, error) {
+	text := w.Template
+	if text == "" {
+		text = DefaultWebhookTemplate
+	}
+	return template.New("webhook").Funcs(webhookFuncs).Parse(text)
+}
+
+// Whether the given kind of event should be posted.
+func (w WebhookConfig) Wants(event string) bool {
+	if w.URL == "" {
+		return false
+	}
+	switch event {
+	case AbandonEvent:
+		return w.Abandons
+	case UnfilledEvent:
+		return w.UnfilledAlerts
+	case CrewEvent:
+		return w.CrewAt != ""
+	}
+	return false
+}
+
+// The JSON which posting the event would send.
+func (w WebhookConfig) Body(event WebhookEvent) ([]byte, error) {
+	t, err := w.template()
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	if err := t.Execute(&body, event); err != nil {
+		return nil, err
+	}
+	if !json.Valid(body.Bytes()) {
+		return nil, fmt.Errorf("webhook template made invalid JSON: %s", body.String())
+	}
+	return body.Bytes(), nil
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// Post the event to the webhook. (Check Wants first.)
+func (w WebhookConfig) Post(event WebhookEvent) error {
+	body, err := w.Body(event)
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("webhook %s said %s: %s", w.URL, resp.Status, bytes.TrimSpace(message))
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package mealplan
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Just enough of the XLSX format to write a table of text (one sheet, no formatting) and read the
+// text back out of the first sheet of a spreadsheet, without depending on a whole spreadsheet
+// library. An XLSX file is a zip of XML files; see ECMA-376 part 1 if you really need to know more.
+
+var xlsxFiles = []struct{ name, contents string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Assignments" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+}
+
+// The name of a column in a spreadsheet: A to Z, then AA, AB...
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// Write the rows as an XLSX spreadsheet, every cell as text.
+func WriteXLSX(w io.Writer, rows [][]string) error {
+	z := zip.NewWriter(w)
+	for _, file := range xlsxFiles {
+		f, err := z.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, file.contents); err != nil {
+			return err
+		}
+	}
+
+	var sheet bytes.Buffer
+	sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for i, row := range rows {
+		fmt.Fprintf(&sheet, `<row r="%d">`, i+1)
+		for j, cell := range row {
+			if cell == "" {
+				continue
+			}
+			fmt.Fprintf(&sheet, `<c r="%s%d" t="inlineStr"><is><t>`, columnName(j), i+1)
+			xml.EscapeText(&sheet, []byte(cell))
+			sheet.WriteString(`</t></is></c>`)
+		}
+		sheet.WriteString(`</row>`)
+	}
+	sheet.WriteString(`</sheetData></worksheet>`)
+	f, err := z.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(sheet.Bytes()); err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+// Text which may be split into differently formatted runs.
+type xlsxText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	s := t.Text
+	for _, run := range t.Runs {
+		s += run.Text
+	}
+	return s
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		Number int `xml:"r,attr"`
+		Cells  []struct {
+			Ref    string   `xml:"r,attr"`
+			Type   string   `xml:"t,attr"`
+			Value  string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// Decode one of the XML files in the zip, if it's there.
+func readZipXML(z *zip.Reader, name string, v interface{}) error {
+	for _, f := range z.File {
+		if f.Name != name {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		// (a few KB of zip can unzip to gigabytes)
+		limited := &io.LimitedReader{R: r, N: maxXLSXPartSize}
+		if err := xml.NewDecoder(limited).Decode(v); err != nil {
+			if limited.N == 0 {
+				return fmt.Errorf("%s is too big (more than %d MB unzipped)", name, maxXLSXPartSize>>20)
+			}
+			return err
+		}
+		return nil
+	}
+	return nil
+}
+
+// The column number (from 0) of a cell reference like "B7". Anything past the last column there can
+// be comes out as maxXLSXColumns.
+func columnNumber(ref string) int {
+	column := 0
+	for _, c := range ref {
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		column = column*26 + int(c-'A'+1)
+		if column > maxXLSXColumns {
+			return maxXLSXColumns
+		}
+	}
+	return column - 1
+}
+
+// Far more rows than any export, so a stray cell way down the sheet doesn't use up all the memory.
+const maxXLSXRows = 100000
+
+// The most columns a sheet can have (XFD), for the same reason.
+const maxXLSXColumns = 16384
+
+// The most any one file in the zip is unzipped to.
+const maxXLSXPartSize = 64 << 20
+
+// Read the text of every cell in the first sheet of an XLSX spreadsheet. Numbers come out the way
+// they're stored, so a date is a number of days (see parseSheetDay).
+func ReadXLSX(r io.ReaderAt, size int64) ([][]string, error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("not an XLSX file: %v", err)
+	}
+
+	var sharedStrings struct {
+		Items []xlsxText `xml:"si"`
+	}
+	if err := readZipXML(z, "xl/sharedStrings.xml", &sharedStrings); err != nil {
+		return nil, err
+	}
+
+	// The first sheet is almost always sheet1.xml; if not, go by name
+	sheetNames := []string{}
+	for _, f := range z.File {
+		if path.Dir(f.Name) == "xl/worksheets" && strings.HasSuffix(f.Name, ".xml") {
+			sheetNames = append(sheetNames, f.Name)
+		}
+	}
+	if len(sheetNames) == 0 {
+		return nil, fmt.Errorf("not an XLSX file: there are no sheets")
+	}
+	sort.Strings(sheetNames)
+	sheetName := sheetNames[0]
+	for _, name := range sheetNames {
+		if name == "xl/worksheets/sheet1.xml" {
+			sheetName = name
+		}
+	}
+	var sheet xlsxSheet
+	if err := readZipXML(z, sheetName, &sheet); err != nil {
+		return nil, err
+	}
+
+	rows := [][]string{}
+	for _, row := range sheet.Rows {
+		if row.Number == 0 {
+			row.Number = len(rows) + 1
+		} else if row.Number < 0 {
+			return nil, fmt.Errorf("there's a row numbered %d", row.Number)
+		} else if row.Number > maxXLSXRows {
+			return nil, fmt.Errorf("there are too many rows (more than %d)", maxXLSXRows)
+		}
+		for len(rows) < row.Number {
+			rows = append(rows, nil)
+		}
+		cells := []string{}
+		for _, cell := range row.Cells {
+			column := len(cells)
+			if cell.Ref != "" {
+				column = columnNumber(cell.Ref)
+			}
+			if column >= maxXLSXColumns {
+				return nil, fmt.Errorf("cell %s is past the last column there can be", cell.Ref)
+			}
+			if column < 0 {
+				continue
+			}
+			value := cell.Value
+			switch cell.Type {
+			case "s":
+				var i int
+				if _, err := fmt.Sscan(cell.Value, &i); err != nil || i < 0 || i >= len(sharedStrings.Items) {
+					return nil, fmt.Errorf("cell %s refers to a string that isn't there", cell.Ref)
+				}
+				value = sharedStrings.Items[i].String()
+			case "inlineStr":
+				value = cell.Inline.String()
+			}
+			for len(cells) <= column {
+				cells = append(cells, "")
+			}
+			cells[column] = value
+		}
+		rows[row.Number-1] = cells
+	}
+	return rows, nil
+}
@@ -0,0 +1,122 @@
+package mealplan
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestXLSXRoundTrip(t *testing.T) {
+	rows := [][]string{
+		{"Day", "Big Cook", "Little Cook", "Cleaner 1"},
+		{"2026-10-19", "alice", "bob"},
+		{},
+		{"2026-10-21", "_", "<carol & dave>", ""},
+	}
+	var buf bytes.Buffer
+	if err := WriteXLSX(&buf, rows); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadTable(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// (empty cells at the end of a row aren't written at all)
+	want := [][]string{
+		{"Day", "Big Cook", "Little Cook", "Cleaner 1"},
+		{"2026-10-19", "alice", "bob"},
+		{},
+		{"2026-10-21", "_", "<carol & dave>"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read back %q, want %q", got, want)
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 701: "ZZ", 702: "AAA", maxXLSXColumns - 1: "XFD"} {
+		if got := columnName(i); got != want {
+			t.Errorf("columnName(%d) = %q, want %q", i, got, want)
+		}
+		if got := columnNumber(want + "12"); got != i {
+			t.Errorf("columnNumber(%q) = %d, want %d", want+"12", got, i)
+		}
+	}
+}
+
+// An XLSX file with the given sheet, and shared strings if there are any.
+func makeXLSX(t *testing.T, sheet, sharedStrings string) []byte {
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+	files := []struct{ name, contents string }{{"xl/worksheets/sheet1.xml", sheet}}
+	if sharedStrings != "" {
+		files = append(files, struct{ name, contents string }{"xl/sharedStrings.xml", sharedStrings})
+	}
+	for _, file := range files {
+		f, err := z.Create(file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(file.contents))
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func emptyZip(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := zip.NewWriter(&buf).Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+const sheetStart = `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+const sheetEnd = `</sheetData></worksheet>`
+
+func TestReadXLSXAsExcelWritesIt(t *testing.T) {
+	// Shared strings (one in formatted runs), a date as a number, rows and cells skipped
+	contents := makeXLSX(t,
+		sheetStart+`<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>`+
+			`<row r="3"><c r="A3"><v>46314</v></c><c r="C3" t="s"><v>2</v></c></row>`+sheetEnd,
+		`<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><si><t>Day</t></si><si><t>Big Cook</t></si>`+
+			`<si><r><t>al</t></r><r><t>ice</t></r></si></sst>`)
+	got, err := ReadXLSX(bytes.NewReader(contents), int64(len(contents)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"Day", "Big Cook"}, nil, {"46314", "alice"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read %q, want %q", got, want)
+	}
+	if day, err := parseSheetDay(got[2][0]); err != nil || day != "2026-10-19" {
+		t.Errorf("parseSheetDay(%q) = %q, %v; want 2026-10-19", got[2][0], day, err)
+	}
+}
+
+func TestReadXLSXBadInput(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		contents []byte
+		wantErr  string
+	}{
+		{"not a zip", []byte("PK\x03\x04 but not really"), "not an XLSX file"},
+		{"no sheets", emptyZip(t), "not an XLSX file"},
+		{"bad XML", makeXLSX(t, sheetStart+`<row>`, ""), "EOF"},
+		{"negative row", makeXLSX(t, sheetStart+`<row r="-1"><c><v>1</v></c></row>`+sheetEnd, ""), "row numbered -1"},
+		{"too many rows", makeXLSX(t, sheetStart+`<row r="100001"><c><v>1</v></c></row>`+sheetEnd, ""), "too many rows"},
+		{"past the last column", makeXLSX(t, sheetStart+`<row r="1"><c r="XFE1"><v>1</v></c></row>`+sheetEnd, ""), "past the last column"},
+		{"way past the last column", makeXLSX(t, sheetStart+`<row r="1"><c r="ZZZZZZZZZZZZZZZ1"><v>1</v></c></row>`+sheetEnd, ""), "past the last column"},
+		{"missing shared string", makeXLSX(t, sheetStart+`<row r="1"><c r="A1" t="s"><v>3</v></c></row>`+sheetEnd, ""), "isn't there"},
+		{"zip bomb", makeXLSX(t, sheetStart+strings.Repeat(" ", maxXLSXPartSize)+sheetEnd, ""), "too big"},
+	} {
+		_, err := ReadXLSX(bytes.NewReader(test.contents), int64(len(test.contents)))
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s: got error %v, want one about %q", test.name, err, test.wantErr)
+		}
+	}
+}